@@ -0,0 +1,185 @@
+package kv
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Counter is implemented by stores with atomic integer counters. The ttl is
+// applied only when the increment creates the key. Counters are kept apart
+// from values: they are read back with Incr(key, 0, ttl), Get of a counter
+// fails with ErrWrongType and Incr of a value with ErrNotInteger.
+type Counter interface {
+	Incr(key string, delta int64, ttl time.Duration) (int64, error)
+	Decr(key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// Conditional is implemented by stores with atomic conditional writes.
+// SetNX stores o only if key is absent. CompareAndSwap replaces the value of
+// key with new only if it currently equals old, and returns ErrKeyMiss if
+// key is absent.
+type Conditional interface {
+	SetNX(key string, o interface{}, ttl time.Duration) (bool, error)
+	CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error)
+}
+
+var (
+	_ Counter     = (*Memory)(nil)
+	_ Counter     = (*Redis)(nil)
+	_ Conditional = (*Memory)(nil)
+	_ Conditional = (*Redis)(nil)
+)
+
+// counter is the value of a Memory counter, so it can be told apart from an
+// int64 stored with Set.
+type counter int64
+
+func (m *Memory) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	e, found := m.get(key)
+	if !found {
+		m.set(key, counter(delta), ttl)
+		return delta, nil
+	}
+	n, ok := e.value.(counter)
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	m.update(e, n+counter(delta))
+	return int64(n) + delta, nil
+}
+
+func (m *Memory) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return m.Incr(key, -delta, ttl)
+}
+
+func (m *Memory) SetNX(key string, o interface{}, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	if _, found := m.lookup(key); found {
+		return false, nil
+	}
+	m.set(key, o, ttl)
+	return true, nil
+}
+
+// CompareAndSwap compares values with reflect.DeepEqual.
+func (m *Memory) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	e, found := m.get(key)
+	if !found {
+		return false, ErrKeyMiss
+	}
+	if !reflect.DeepEqual(e.value, old) {
+		return false, nil
+	}
+	m.set(key, new, ttl)
+	return true, nil
+}
+
+// incrScript keeps counters in a hash, so GET of a counter fails rather than
+// handing its decimal text to the codec.
+var incrScript = redis.NewScript(`
+local created = redis.call('EXISTS', KEYS[1]) == 0
+local n = redis.call('HINCRBY', KEYS[1], 'n', ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return n
+`)
+
+var casScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur then
+	return -1
+end
+if cur ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+func (r *Redis) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	n, err := runInt(incrScript, r.client, []string{key}, delta, milliseconds(ttl))
+	if err != nil && (strings.Contains(err.Error(), "not an integer") || strings.Contains(err.Error(), "WRONGTYPE")) {
+		return 0, ErrNotInteger
+	}
+	return n, err
+}
+
+func (r *Redis) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return r.Incr(key, -delta, ttl)
+}
+
+func (r *Redis) SetNX(key string, o interface{}, ttl time.Duration) (bool, error) {
+	b, err := r.codec.Marshal(o)
+	if err != nil {
+		return false, err
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return r.client.SetNX(key, b, ttl).Result()
+}
+
+// CompareAndSwap compares values by their encoded form, so old must encode
+// exactly as the stored value did. It fails with ErrUnsupported under a
+// codec that does not encode a value the same way every time, such as
+// Encrypt.
+func (r *Redis) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	if !deterministic(r.codec) {
+		return false, ErrUnsupported
+	}
+	oldb, err := r.codec.Marshal(old)
+	if err != nil {
+		return false, err
+	}
+	newb, err := r.codec.Marshal(new)
+	if err != nil {
+		return false, err
+	}
+	res, err := runInt(casScript, r.client, []string{key}, oldb, newb, milliseconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	switch res {
+	case -1:
+		return false, ErrKeyMiss
+	case 0:
+		return false, nil
+	}
+	return true, nil
+}
+
+func runInt(script *redis.Script, client rediser, keys []string, args ...interface{}) (int64, error) {
+	v, err := script.Run(client, keys, args...).Result()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, errUnexpectedReply
+	}
+	return n, nil
+}
+
+// milliseconds formats ttl as a script argument; values <= 0 mean no expiration.
+func milliseconds(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl < time.Millisecond {
+		return 1
+	}
+	return int64(ttl / time.Millisecond)
+}
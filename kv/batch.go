@@ -0,0 +1,216 @@
+package kv
+
+import (
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Batcher is implemented by stores that can read and write many keys at
+// once. MGet fills objs[i] with the value of keys[i] and reports for each
+// key whether it was found; objs of missing keys are left untouched. Like
+// Redis MGET, keys holding counters or structures count as missing.
+type Batcher interface {
+	MGet(keys []string, objs []interface{}) ([]bool, error)
+	MSet(keys []string, objs []interface{}, ttl time.Duration) error
+	MDel(keys ...string) error
+}
+
+var (
+	_ Batcher = (*Memory)(nil)
+	_ Batcher = (*Redis)(nil)
+)
+
+func (m *Memory) MGet(keys []string, objs []interface{}) ([]bool, error) {
+	if len(keys) != len(objs) {
+		return nil, ErrBatchLength
+	}
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		err := m.Get(key, objs[i])
+		if err == ErrKeyMiss || err == ErrWrongType {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+func (m *Memory) MSet(keys []string, objs []interface{}, ttl time.Duration) error {
+	if len(keys) != len(objs) {
+		return ErrBatchLength
+	}
+	for i, key := range keys {
+		m.Set(key, objs[i], ttl)
+	}
+	return nil
+}
+
+func (m *Memory) MDel(keys ...string) error {
+	for _, key := range keys {
+		m.Del(key)
+	}
+	return nil
+}
+
+func (r *Redis) MGet(keys []string, objs []interface{}) ([]bool, error) {
+	if len(keys) != len(objs) {
+		return nil, ErrBatchLength
+	}
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	groups := r.groupKeys(keys)
+	cmds := make([]*redis.SliceCmd, len(groups))
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		for i, group := range groups {
+			cmds[i] = pipe.MGet(pick(keys, group)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, group := range groups {
+		for j, v := range cmds[i].Val() {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if err := r.codec.Unmarshal([]byte(s), objs[group[j]]); err != nil {
+				return nil, &DecodeError{Key: keys[group[j]], Err: err}
+			}
+			found[group[j]] = true
+		}
+	}
+	return found, nil
+}
+
+func (r *Redis) MSet(keys []string, objs []interface{}, ttl time.Duration) error {
+	if len(keys) != len(objs) {
+		return ErrBatchLength
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values := make([][]byte, len(objs))
+	for i, o := range objs {
+		b, err := r.codec.Marshal(o)
+		if err != nil {
+			return err
+		}
+		values[i] = b
+	}
+	// MSET has no expiration, so pipeline one SET per key instead
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		for i, key := range keys {
+			pipe.Set(key, values[i], ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) MDel(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	groups := r.groupKeys(keys)
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		for _, group := range groups {
+			pipe.Del(pick(keys, group)...)
+		}
+		return nil
+	})
+	return err
+}
+
+// groupKeys splits keys into groups that a single multi-key command can
+// serve, returning the indexes of the keys in each group. A cluster only
+// accepts multi-key commands whose keys share a hash slot.
+func (r *Redis) groupKeys(keys []string) [][]int {
+	if _, ok := r.client.(*redis.ClusterClient); !ok {
+		all := make([]int, len(keys))
+		for i := range keys {
+			all[i] = i
+		}
+		return [][]int{all}
+	}
+
+	slots := make(map[int]int)
+	var groups [][]int
+	for i, key := range keys {
+		slot := hashSlot(key)
+		g, ok := slots[slot]
+		if !ok {
+			g = len(groups)
+			slots[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+func pick(keys []string, indexes []int) []string {
+	picked := make([]string, len(indexes))
+	for i, index := range indexes {
+		picked[i] = keys[index]
+	}
+	return picked
+}
+
+func mget(s Store, keys []string, objs []interface{}) ([]bool, error) {
+	if b, ok := s.(Batcher); ok {
+		return b.MGet(keys, objs)
+	}
+	if len(keys) != len(objs) {
+		return nil, ErrBatchLength
+	}
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		err := s.Get(key, objs[i])
+		if err == ErrKeyMiss || err == ErrWrongType {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+func mset(s Store, keys []string, objs []interface{}, ttl time.Duration) error {
+	if b, ok := s.(Batcher); ok {
+		return b.MSet(keys, objs, ttl)
+	}
+	if len(keys) != len(objs) {
+		return ErrBatchLength
+	}
+	for i, key := range keys {
+		if err := s.Set(key, objs[i], ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mdel(s Store, keys []string) error {
+	if b, ok := s.(Batcher); ok {
+		return b.MDel(keys...)
+	}
+	for _, key := range keys {
+		if err := s.Del(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
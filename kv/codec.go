@@ -0,0 +1,151 @@
+package kv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+
+	"github.com/xtimeline/gox/json"
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// Codec converts values to and from the bytes stored in a backend.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+var (
+	MsgpackCodec Codec = msgpackCodec{}
+	JsonCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	// RawCodec stores []byte and string values as they are and reads them
+	// back into *[]byte or *string.
+	RawCodec Codec = rawCodec{}
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(b []byte, v interface{}) error {
+	return msgpack.Unmarshal(b, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case []byte:
+		return x, nil
+	case string:
+		return []byte(x), nil
+	}
+	return nil, ErrTypeMismatch
+}
+
+func (rawCodec) Unmarshal(b []byte, v interface{}) error {
+	switch x := v.(type) {
+	case *[]byte:
+		*x = append((*x)[:0], b...)
+		return nil
+	case *string:
+		*x = string(b)
+		return nil
+	}
+	return ErrTypeMismatch
+}
+
+type compressCodec struct {
+	codec     Codec
+	threshold int
+}
+
+// Compress wraps codec so encoded values of at least threshold bytes are
+// gzipped. Smaller values are stored as codec encodes them, so they stay
+// readable by other languages and values written before compression was
+// enabled still decode. Compressed values are told apart by the gzip magic
+// bytes, which neither msgpack nor JSON values start with.
+func Compress(codec Codec, threshold int) Codec {
+	return compressCodec{codec: codec, threshold: threshold}
+}
+
+// deterministic reports whether codec encodes equal values to equal bytes.
+func deterministic(codec Codec) bool {
+	switch c := codec.(type) {
+	case encryptCodec:
+		return false
+	case compressCodec:
+		return deterministic(c.codec)
+	}
+	return true
+}
+
+func (c compressCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < c.threshold {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compressCodec) Unmarshal(b []byte, v interface{}) error {
+	if !isGzip(b) {
+		return c.codec.Unmarshal(b, v)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	plain, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(plain, v)
+}
+
+// isGzip reports whether b starts with the gzip magic bytes and the deflate
+// method.
+func isGzip(b []byte) bool {
+	return len(b) >= 3 && b[0] == 0x1f && b[1] == 0x8b && b[2] == 8
+}
@@ -0,0 +1,33 @@
+package kv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+func TestCompress(t *testing.T) {
+	c := kv.Compress(kv.JsonCodec, 64)
+
+	b, err := c.Marshal(map[string]int{"a": 1})
+	if err != nil || string(b) != `{"a":1}` {
+		t.Fatalf("Marshal of small value: got %q, %v; want it unchanged", b, err)
+	}
+
+	long := strings.Repeat("x", 1000)
+	b, err = c.Marshal(long)
+	if err != nil || len(b) >= len(long) {
+		t.Fatalf("Marshal of large value: got %d bytes, %v; want it compressed", len(b), err)
+	}
+	var s string
+	if err := c.Unmarshal(b, &s); err != nil || s != long {
+		t.Fatalf("Unmarshal of compressed value: %v", err)
+	}
+
+	// values written before compression was enabled
+	plain, _ := kv.JsonCodec.Marshal(long)
+	if err := c.Unmarshal(plain, &s); err != nil || s != long {
+		t.Fatalf("Unmarshal of uncompressed value: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package kv
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Do runs fn and waits for it until ctx is done. Stores cannot abort an
+// operation halfway, so fn keeps running in the background after Do gave
+// up. Deadlines, including network timeouts reported by fn, surface as
+// ErrTimeout.
+func Do(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return timeoutErr(fn())
+	}
+	if err := ctx.Err(); err != nil {
+		return timeoutErr(err)
+	}
+	c := make(chan error, 1)
+	go func() {
+		c <- fn()
+	}()
+	select {
+	case <-ctx.Done():
+		return timeoutErr(ctx.Err())
+	case err := <-c:
+		return timeoutErr(err)
+	}
+}
+
+func timeoutErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
+
+// ContextStore exposes the operations of a store with a leading
+// context.Context. Operations the wrapped store does not implement return
+// ErrUnsupported.
+type ContextStore struct {
+	store Store
+}
+
+func WithContext(s Store) *ContextStore {
+	return &ContextStore{store: s}
+}
+
+func (c *ContextStore) Set(ctx context.Context, key string, o interface{}, ttl time.Duration) error {
+	return Do(ctx, func() error {
+		return c.store.Set(key, o, ttl)
+	})
+}
+
+func (c *ContextStore) Get(ctx context.Context, key string, o interface{}) error {
+	// decode into a private value so an abandoned Get cannot write to o
+	tmp, err := newTarget(o)
+	if err != nil {
+		return err
+	}
+	if err := Do(ctx, func() error {
+		return c.store.Get(key, tmp)
+	}); err != nil {
+		return err
+	}
+	copyTarget(o, tmp)
+	return nil
+}
+
+func (c *ContextStore) Del(ctx context.Context, key string) error {
+	return Do(ctx, func() error {
+		return c.store.Del(key)
+	})
+}
+
+func (c *ContextStore) MGet(ctx context.Context, keys []string, objs []interface{}) ([]bool, error) {
+	tmps := make([]interface{}, len(objs))
+	for i, o := range objs {
+		tmp, err := newTarget(o)
+		if err != nil {
+			return nil, err
+		}
+		tmps[i] = tmp
+	}
+	var found []bool
+	if err := Do(ctx, func() error {
+		var err error
+		found, err = mget(c.store, keys, tmps)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	for i, ok := range found {
+		if !ok {
+			continue
+		}
+		copyTarget(objs[i], tmps[i])
+	}
+	return found, nil
+}
+
+func (c *ContextStore) MSet(ctx context.Context, keys []string, objs []interface{}, ttl time.Duration) error {
+	return Do(ctx, func() error {
+		return mset(c.store, keys, objs, ttl)
+	})
+}
+
+func (c *ContextStore) MDel(ctx context.Context, keys ...string) error {
+	return Do(ctx, func() error {
+		return mdel(c.store, keys)
+	})
+}
+
+func (c *ContextStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, ok := c.store.(Counter)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	var n int64
+	err := Do(ctx, func() error {
+		var err error
+		n, err = counter.Incr(key, delta, ttl)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ContextStore) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Incr(ctx, key, -delta, ttl)
+}
+
+func (c *ContextStore) SetNX(ctx context.Context, key string, o interface{}, ttl time.Duration) (bool, error) {
+	cond, ok := c.store.(Conditional)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	var set bool
+	err := Do(ctx, func() error {
+		var err error
+		set, err = cond.SetNX(key, o, ttl)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return set, nil
+}
+
+func (c *ContextStore) CompareAndSwap(ctx context.Context, key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	cond, ok := c.store.(Conditional)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	var swapped bool
+	err := Do(ctx, func() error {
+		var err error
+		swapped, err = cond.CompareAndSwap(key, old, new, ttl)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+func (c *ContextStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	x, ok := c.store.(Expirer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	var ttl time.Duration
+	err := Do(ctx, func() error {
+		var err error
+		ttl, err = x.TTL(key)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (c *ContextStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	x, ok := c.store.(Expirer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return Do(ctx, func() error {
+		return x.Expire(key, ttl)
+	})
+}
+
+func (c *ContextStore) Persist(ctx context.Context, key string) error {
+	x, ok := c.store.(Expirer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return Do(ctx, func() error {
+		return x.Persist(key)
+	})
+}
+
+func (c *ContextStore) GetOrLoad(ctx context.Context, key string, o interface{}, ttl time.Duration, load LoadFunc) error {
+	tmp, err := newTarget(o)
+	if err != nil {
+		return err
+	}
+	if err := Do(ctx, func() error {
+		return GetOrLoad(c.store, key, tmp, ttl, load)
+	}); err != nil {
+		return err
+	}
+	copyTarget(o, tmp)
+	return nil
+}
+
+func (c *ContextStore) GetOrLoadNegative(ctx context.Context, key string, o interface{}, ttl, negTTL time.Duration, load LoadFunc) error {
+	tmp, err := newTarget(o)
+	if err != nil {
+		return err
+	}
+	if err := Do(ctx, func() error {
+		return GetOrLoadNegative(c.store, key, tmp, ttl, negTTL, load)
+	}); err != nil {
+		return err
+	}
+	copyTarget(o, tmp)
+	return nil
+}
+
+// newTarget allocates a value of the type o points to.
+func newTarget(o interface{}) (interface{}, error) {
+	v := reflect.ValueOf(o)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, ErrInvalidTarget
+	}
+	return reflect.New(v.Type().Elem()).Interface(), nil
+}
+
+// copyTarget copies the value tmp points to into the value o points to.
+func copyTarget(o, tmp interface{}) {
+	reflect.ValueOf(o).Elem().Set(reflect.ValueOf(tmp).Elem())
+}
@@ -0,0 +1,552 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtimeline/gox/log"
+)
+
+type diskOptions struct {
+	codec           Codec
+	sync            bool
+	compactInterval time.Duration
+}
+
+type DiskOption func(opts *diskOptions)
+
+// DiskCodec sets how values are encoded in the log. The default is
+// MsgpackCodec.
+func DiskCodec(v Codec) DiskOption {
+	return func(opts *diskOptions) {
+		opts.codec = v
+	}
+}
+
+// SyncWrites makes every write wait for the log to reach stable storage.
+// Without it writes survive a crash of the process but not of the machine.
+func SyncWrites() DiskOption {
+	return func(opts *diskOptions) {
+		opts.sync = true
+	}
+}
+
+// CompactInterval sets how often the log is checked for compaction. It is
+// rewritten once more than half of it is taken by overwritten, deleted or
+// expired entries. A value <= 0 disables background compaction.
+func CompactInterval(v time.Duration) DiskOption {
+	return func(opts *diskOptions) {
+		opts.compactInterval = v
+	}
+}
+
+// A log record is laid out as
+//
+//	checksum uint32  CRC-32 (IEEE) of the rest of the record
+//	op       uint8   1 sets a value, 2 deletes, 3 sets a counter
+//	expires  int64   unix nanoseconds, 0 if the entry never expires
+//	keyLen   uint32
+//	valueLen uint32
+//	key      [keyLen]byte
+//	value    [valueLen]byte
+//
+// with all integers big endian.
+const recordHeaderSize = 4 + 1 + 8 + 4 + 4
+
+const (
+	opSet byte = iota + 1
+	opDel
+	opCounter
+)
+
+// the log is not rewritten before this many bytes are stale
+const compactMinStale = 1 << 20
+
+var errTornRecord = errors.New("cache: torn record at the end of the log")
+
+type diskEntry struct {
+	// op is opSet or opCounter
+	op      byte
+	value   []byte
+	expires int64
+	size    int64
+}
+
+// Disk is a store persisted to a single append-only log file, for
+// deployments without Redis that need their data to survive restarts. All
+// entries are kept in memory as well, and the file must not be shared by
+// several processes.
+//
+// A record torn by a crash is dropped when the log is opened again.
+type Disk struct {
+	path  string
+	opts  diskOptions
+	mu    sync.Mutex
+	f     *os.File
+	items map[string]*diskEntry
+	// size is the length of the log, live the part of it backing items
+	size   int64
+	live   int64
+	closed chan struct{}
+}
+
+var (
+	_ Store   = (*Disk)(nil)
+	_ Counter = (*Disk)(nil)
+	_ Expirer = (*Disk)(nil)
+	_ Scanner = (*Disk)(nil)
+)
+
+// OpenDisk opens the log at path, creating it if needed, and replays it.
+// A record torn by a crash at the end of the log is cut off; a damaged
+// record before the end fails with ErrDiskCorrupt and leaves the file as is.
+func OpenDisk(path string, opts ...DiskOption) (*Disk, error) {
+	diskOps := diskOptions{
+		codec:           MsgpackCodec,
+		compactInterval: 10 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&diskOps)
+	}
+
+	// a compaction interrupted by a crash leaves its output behind
+	os.Remove(compactPath(path))
+
+	d := &Disk{
+		path:   path,
+		opts:   diskOps,
+		items:  make(map[string]*diskEntry),
+		closed: make(chan struct{}),
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	if diskOps.compactInterval > 0 {
+		go d.compactor()
+	}
+	return d, nil
+}
+
+func (d *Disk) Set(key string, o interface{}, ttl time.Duration) error {
+	b, err := d.opts.codec.Marshal(o)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.put(opSet, key, b, deadline(ttl))
+}
+
+func (d *Disk) Get(key string, o interface{}) error {
+	d.mu.Lock()
+	e, err := d.lookup(key)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if e.op == opCounter {
+		return ErrWrongType
+	}
+	if err := d.opts.codec.Unmarshal(e.value, o); err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
+	return nil
+}
+
+func (d *Disk) Del(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return ErrClosed
+	}
+	if _, found := d.items[key]; !found {
+		return nil
+	}
+	return d.append(opDel, key, nil, 0)
+}
+
+func (d *Disk) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, err := d.lookup(key)
+	var n int64
+	expires := deadline(ttl)
+	switch err {
+	case nil:
+		if e.op != opCounter {
+			return 0, ErrNotInteger
+		}
+		if err := d.opts.codec.Unmarshal(e.value, &n); err != nil {
+			return 0, ErrNotInteger
+		}
+		expires = e.expires
+	case ErrKeyMiss:
+	default:
+		return 0, err
+	}
+	if err := d.putInt(key, n+delta, expires); err != nil {
+		return 0, err
+	}
+	return n + delta, nil
+}
+
+func (d *Disk) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return d.Incr(key, -delta, ttl)
+}
+
+func (d *Disk) TTL(key string) (time.Duration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, err := d.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	if e.expires == 0 {
+		return 0, nil
+	}
+	return time.Duration(e.expires - time.Now().UnixNano()), nil
+}
+
+func (d *Disk) Expire(key string, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, err := d.lookup(key)
+	if err != nil {
+		return err
+	}
+	return d.put(e.op, key, e.value, deadline(ttl))
+}
+
+func (d *Disk) Persist(key string) error {
+	return d.Expire(key, 0)
+}
+
+// Scan iterates over a sorted snapshot of the live keys taken when the
+// iteration starts.
+func (d *Disk) Scan(match string) *Iterator {
+	return &Iterator{fetch: func() ([]string, bool, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.f == nil {
+			return nil, false, ErrClosed
+		}
+		now := time.Now().UnixNano()
+		var keys []string
+		for key, e := range d.items {
+			if !expired(e.expires, now) && globMatch(match, key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys, true, nil
+	}}
+}
+
+// Compact rewrites the log with only the live entries.
+func (d *Disk) Compact() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return ErrClosed
+	}
+	return d.compact()
+}
+
+// Close stops background compaction and closes the log.
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.closed:
+		return nil
+	default:
+	}
+	close(d.closed)
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
+
+// lookup returns the live entry of key, forgetting it if it expired.
+func (d *Disk) lookup(key string) (*diskEntry, error) {
+	if d.f == nil {
+		return nil, ErrClosed
+	}
+	e, found := d.items[key]
+	if !found {
+		return nil, ErrKeyMiss
+	}
+	if expired(e.expires, time.Now().UnixNano()) {
+		// the record stays in the log until the next compaction
+		d.forget(key)
+		return nil, ErrKeyMiss
+	}
+	return e, nil
+}
+
+func (d *Disk) put(op byte, key string, value []byte, expires int64) error {
+	if d.f == nil {
+		return ErrClosed
+	}
+	return d.append(op, key, value, expires)
+}
+
+func (d *Disk) putInt(key string, n int64, expires int64) error {
+	b, err := d.opts.codec.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return d.put(opCounter, key, b, expires)
+}
+
+// append writes a record to the log and applies it to items.
+func (d *Disk) append(op byte, key string, value []byte, expires int64) error {
+	rec := encodeRecord(op, key, value, expires)
+	if _, err := d.f.Write(rec); err != nil {
+		// drop a partial record, or it would hide every later one on replay
+		d.f.Truncate(d.size)
+		return err
+	}
+	d.size += int64(len(rec))
+	d.apply(op, key, value, expires, int64(len(rec)))
+	if d.opts.sync {
+		return d.f.Sync()
+	}
+	return nil
+}
+
+func (d *Disk) apply(op byte, key string, value []byte, expires int64, size int64) {
+	d.forget(key)
+	if op != opDel && !expired(expires, time.Now().UnixNano()) {
+		d.items[key] = &diskEntry{op: op, value: value, expires: expires, size: size}
+		d.live += size
+	}
+}
+
+func (d *Disk) forget(key string) {
+	if e, found := d.items[key]; found {
+		delete(d.items, key)
+		d.live -= e.size
+	}
+}
+
+// open opens the log for appending and replays it into items. A torn
+// record at the end is cut off.
+func (d *Disk) open() error {
+	f, err := os.OpenFile(d.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	d.items = make(map[string]*diskEntry)
+	d.size, d.live = 0, 0
+	r := bufio.NewReader(f)
+	for {
+		op, key, value, expires, size, err := readRecord(r, info.Size()-d.size)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornRecord {
+			if err := f.Truncate(d.size); err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			l.PithyWarn("kv disk log corrupt", map[string]interface{}{
+				"path":   d.path,
+				"offset": d.size,
+			})
+			return ErrDiskCorrupt
+		}
+		d.size += size
+		d.apply(op, key, value, expires, size)
+	}
+	d.f = f
+	return nil
+}
+
+func (d *Disk) compact() error {
+	tmp := compactPath(d.path)
+	if err := d.writeLive(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(d.path))
+
+	// the old descriptor still refers to the replaced file
+	d.f.Close()
+	d.f = nil
+	return d.open()
+}
+
+func (d *Disk) writeLive(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	now := time.Now().UnixNano()
+	for key, e := range d.items {
+		if expired(e.expires, now) {
+			continue
+		}
+		if _, err := w.Write(encodeRecord(e.op, key, e.value, e.expires)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (d *Disk) compactor() {
+	ticker := time.NewTicker(d.opts.compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closed:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		stale := d.size - d.live
+		if d.f != nil && stale >= compactMinStale && stale > d.live {
+			if err := d.compact(); err != nil {
+				l.PithyWarn("kv disk compaction failed", map[string]interface{}{
+					"path":  d.path,
+					"error": err.Error(),
+				})
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+// syncDir makes a rename in dir durable. Not every platform supports it, so
+// failures are ignored.
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	f.Sync()
+	f.Close()
+}
+
+func expired(expires, now int64) bool {
+	return expires > 0 && now >= expires
+}
+
+func encodeRecord(op byte, key string, value []byte, expires int64) []byte {
+	rec := make([]byte, recordHeaderSize+len(key)+len(value))
+	rec[4] = op
+	binary.BigEndian.PutUint64(rec[5:], uint64(expires))
+	binary.BigEndian.PutUint32(rec[13:], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[17:], uint32(len(value)))
+	copy(rec[recordHeaderSize:], key)
+	copy(rec[recordHeaderSize+len(key):], value)
+	binary.BigEndian.PutUint32(rec, crc32.ChecksumIEEE(rec[4:]))
+	return rec
+}
+
+// readRecord reads the next record of a log with remaining bytes left. It
+// returns io.EOF at the clean end of the log, errTornRecord for a record
+// reaching past or damaged at the end, and ErrCorruptValue for a damaged
+// record before it. A record claiming to reach past the end is only torn if
+// no intact record follows it; otherwise its length is damaged.
+func readRecord(r io.Reader, remaining int64) (op byte, key string, value []byte, expires int64, size int64, err error) {
+	if remaining == 0 {
+		return 0, "", nil, 0, 0, io.EOF
+	}
+	var h [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, "", nil, 0, 0, errTornRecord
+	}
+	keyLen := int64(binary.BigEndian.Uint32(h[13:]))
+	valueLen := int64(binary.BigEndian.Uint32(h[17:]))
+	size = recordHeaderSize + keyLen + valueLen
+	if size > remaining {
+		rest := make([]byte, remaining)
+		copy(rest, h[:])
+		if _, err := io.ReadFull(r, rest[recordHeaderSize:]); err != nil {
+			return 0, "", nil, 0, 0, errTornRecord
+		}
+		if holdsRecord(rest[1:]) {
+			return 0, "", nil, 0, 0, ErrCorruptValue
+		}
+		return 0, "", nil, 0, 0, errTornRecord
+	}
+	body := make([]byte, keyLen+valueLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, "", nil, 0, 0, errTornRecord
+	}
+	damaged := ErrCorruptValue
+	if size == remaining {
+		damaged = errTornRecord
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(h[4:])
+	sum.Write(body)
+	if sum.Sum32() != binary.BigEndian.Uint32(h[:]) {
+		return 0, "", nil, 0, 0, damaged
+	}
+	op = h[4]
+	if !validOp(op) {
+		return 0, "", nil, 0, 0, damaged
+	}
+	expires = int64(binary.BigEndian.Uint64(h[5:]))
+	return op, string(body[:keyLen]), body[keyLen:], expires, size, nil
+}
+
+// holdsRecord reports whether an intact record starts anywhere in b.
+func holdsRecord(b []byte) bool {
+	for i := 0; i+recordHeaderSize <= len(b); i++ {
+		h := b[i:]
+		size := int64(recordHeaderSize) + int64(binary.BigEndian.Uint32(h[13:])) + int64(binary.BigEndian.Uint32(h[17:]))
+		if size > int64(len(h)) || !validOp(h[4]) {
+			continue
+		}
+		if crc32.ChecksumIEEE(h[4:size]) == binary.BigEndian.Uint32(h) {
+			return true
+		}
+	}
+	return false
+}
+
+func validOp(op byte) bool {
+	return op == opSet || op == opDel || op == opCounter
+}
@@ -0,0 +1,175 @@
+package kv_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+func openDisk(t *testing.T, path string) *kv.Disk {
+	d, err := kv.OpenDisk(path, kv.CompactInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func diskPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kvdisk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "log")
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestDiskReplay(t *testing.T) {
+	path := diskPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	d := openDisk(t, path)
+	d.Set("a", "a1", 0)
+	d.Set("a", "a2", 0)
+	d.Set("b", "b", 0)
+	d.Del("b")
+	d.Set("ttl", "ttl", time.Hour)
+	d.Set("gone", "gone", time.Millisecond)
+	d.Incr("counter", 5, 0)
+	d.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	d = openDisk(t, path)
+	defer d.Close()
+	var s string
+	if err := d.Get("a", &s); err != nil || s != "a2" {
+		t.Errorf("a: got %q, %v", s, err)
+	}
+	if err := d.Get("b", &s); err != kv.ErrKeyMiss {
+		t.Errorf("deleted b: got %v, want ErrKeyMiss", err)
+	}
+	if err := d.Get("gone", &s); err != kv.ErrKeyMiss {
+		t.Errorf("expired gone: got %v, want ErrKeyMiss", err)
+	}
+	if ttl, err := d.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl: got %v, %v", ttl, err)
+	}
+	if n, err := d.Incr("counter", 1, 0); err != nil || n != 6 {
+		t.Errorf("counter: got %d, %v", n, err)
+	}
+}
+
+func TestDiskTornTail(t *testing.T) {
+	path := diskPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	d := openDisk(t, path)
+	d.Set("a", "a", 0)
+	intact := fileSize(t, path)
+	d.Set("b", "bbbbbbbb", 0)
+	d.Close()
+
+	for _, cut := range []int64{1, 5, 30} {
+		if err := os.Truncate(path, fileSize(t, path)-cut); err != nil {
+			t.Fatal(err)
+		}
+		d = openDisk(t, path)
+		var s string
+		if err := d.Get("a", &s); err != nil || s != "a" {
+			t.Errorf("cut %d: a: got %q, %v", cut, s, err)
+		}
+		if err := d.Get("b", &s); err != kv.ErrKeyMiss {
+			t.Errorf("cut %d: torn b: got %v, want ErrKeyMiss", cut, err)
+		}
+		d.Close()
+		if size := fileSize(t, path); size != intact {
+			t.Errorf("cut %d: log is %d bytes, want %d", cut, size, intact)
+		}
+		// write the torn record again for the next cut
+		d = openDisk(t, path)
+		d.Set("b", "bbbbbbbb", 0)
+		d.Close()
+	}
+}
+
+func TestDiskCorrupt(t *testing.T) {
+	for name, offset := range map[string]int{
+		// offsets into the first record
+		"checksum": 0,
+		"length":   17,
+		"value":    22,
+	} {
+		path := diskPath(t)
+		defer os.RemoveAll(filepath.Dir(path))
+		d := openDisk(t, path)
+		d.Set("a", "a", 0)
+		d.Set("b", "b", 0)
+		d.Close()
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[offset] ^= 0x40
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kv.OpenDisk(path, kv.CompactInterval(0)); err != kv.ErrDiskCorrupt {
+			t.Errorf("%s: OpenDisk: got %v, want ErrDiskCorrupt", name, err)
+		}
+		after, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(after, b) {
+			t.Errorf("%s: OpenDisk changed the corrupt log", name)
+		}
+	}
+}
+
+func TestDiskCompact(t *testing.T) {
+	path := diskPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	d := openDisk(t, path)
+	for i := 0; i < 100; i++ {
+		d.Set("a", i, 0)
+	}
+	d.Set("b", "b", 0)
+	d.Del("b")
+	d.Incr("counter", 3, 0)
+	before := fileSize(t, path)
+	if err := d.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if after := fileSize(t, path); after >= before {
+		t.Errorf("Compact: log grew from %d to %d bytes", before, after)
+	}
+	d.Set("c", "c", 0)
+	d.Close()
+
+	d = openDisk(t, path)
+	defer d.Close()
+	var n int
+	if err := d.Get("a", &n); err != nil || n != 99 {
+		t.Errorf("a: got %d, %v", n, err)
+	}
+	var s string
+	if err := d.Get("b", &s); err != kv.ErrKeyMiss {
+		t.Errorf("deleted b: got %v, want ErrKeyMiss", err)
+	}
+	if err := d.Get("c", &s); err != nil || s != "c" {
+		t.Errorf("c: got %q, %v", s, err)
+	}
+	if c, err := d.Incr("counter", 1, 0); err != nil || c != 4 {
+		t.Errorf("counter: got %d, %v", c, err)
+	}
+}
@@ -0,0 +1,127 @@
+package kv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errNoCurrentKey = errors.New("cache: current key is not in the keyring")
+
+// Keyring holds the AES keys values are encrypted with, by ID. New values
+// are encrypted with the current key; the others are only used to decrypt
+// values written before a rotation. Replicas that do not know a key cannot
+// read values written with it, so rotate in three deploys:
+//
+//  1. add the new key to every keyring, keeping the old one current
+//  2. once that is rolled out everywhere, make the new key current
+//  3. once values encrypted with the old key expired, drop it
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from keys of 16, 24 or 32 bytes, selecting
+// AES-128, AES-192 or AES-256. IDs are stored with every value, so they
+// should be short; they may not exceed 255 bytes.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, errNoCurrentKey
+	}
+	k := &Keyring{
+		current: current,
+		aeads:   make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if len(id) > 255 {
+			return nil, fmt.Errorf("cache: key id %q is longer than 255 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("cache: key %q: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+const flagAESGCM byte = 1
+
+type encryptCodec struct {
+	codec Codec
+	keys  *Keyring
+}
+
+// Encrypt wraps codec so values are encrypted with AES-GCM. A value is
+// stored as
+//
+//	flag  byte, 1 for AES-GCM
+//	idLen byte
+//	id    [idLen]byte
+//	nonce [12]byte
+//	ciphertext and tag
+//
+// with the header up to the nonce authenticated along with the value.
+//
+// Every value gets a random nonce, so the same value encrypts to different
+// bytes each time. Operations comparing encoded values therefore fail with
+// ErrUnsupported under this codec: CompareAndSwap on Redis, and the members
+// of sets and sorted sets on any store. Hash fields, list items and plain
+// values work as usual.
+//
+// Values that fail to decrypt, including those encrypted with a key no
+// longer in the keyring, are reported as decode errors. To compress as
+// well, wrap the result of Compress, since ciphertext does not compress.
+func Encrypt(codec Codec, keys *Keyring) Codec {
+	return encryptCodec{codec: codec, keys: keys}
+}
+
+func (c encryptCodec) Marshal(v interface{}) ([]byte, error) {
+	plain, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	id := c.keys.current
+	aead := c.keys.aeads[id]
+
+	header := make([]byte, 0, 2+len(id)+aead.NonceSize()+len(plain)+aead.Overhead())
+	header = append(header, flagAESGCM, byte(len(id)))
+	header = append(header, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	b := append(header, nonce...)
+	return aead.Seal(b, nonce, plain, header), nil
+}
+
+func (c encryptCodec) Unmarshal(b []byte, v interface{}) error {
+	if len(b) < 2 || b[0] != flagAESGCM {
+		return ErrCorruptValue
+	}
+	idEnd := 2 + int(b[1])
+	if len(b) < idEnd {
+		return ErrCorruptValue
+	}
+	id := string(b[2:idEnd])
+	aead, ok := c.keys.aeads[id]
+	if !ok {
+		return fmt.Errorf("cache: value is encrypted with unknown key %q", id)
+	}
+	nonceEnd := idEnd + aead.NonceSize()
+	if len(b) < nonceEnd+aead.Overhead() {
+		return ErrCorruptValue
+	}
+	plain, err := aead.Open(nil, b[idEnd:nonceEnd], b[nonceEnd:], b[:idEnd])
+	if err != nil {
+		return ErrCorruptValue
+	}
+	return c.codec.Unmarshal(plain, v)
+}
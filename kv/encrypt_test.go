@@ -0,0 +1,109 @@
+package kv_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 16)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func keyring(t *testing.T, current string, keys map[string][]byte) *kv.Keyring {
+	k, err := kv.NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncrypt(t *testing.T) {
+	c := kv.Encrypt(kv.MsgpackCodec, keyring(t, "old", map[string][]byte{"old": oldKey}))
+	b, err := c.Marshal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("secret")) {
+		t.Errorf("Marshal: %q holds the plaintext", b)
+	}
+	var s string
+	if err := c.Unmarshal(b, &s); err != nil || s != "secret" {
+		t.Errorf("Unmarshal: got %q, %v", s, err)
+	}
+
+	// after a rotation values written with the old key still decrypt
+	rotated := kv.Encrypt(kv.MsgpackCodec, keyring(t, "new", map[string][]byte{"old": oldKey, "new": newKey}))
+	if err := rotated.Unmarshal(b, &s); err != nil || s != "secret" {
+		t.Errorf("Unmarshal after rotation: got %q, %v", s, err)
+	}
+	nb, err := rotated.Marshal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unmarshal(nb, &s); err == nil {
+		t.Error("Unmarshal of a value encrypted with an unknown key: want error")
+	}
+
+	for i := range b {
+		tampered := append([]byte(nil), b...)
+		tampered[i] ^= 1
+		if err := c.Unmarshal(tampered, &s); err == nil {
+			t.Errorf("Unmarshal with byte %d flipped: want error", i)
+		}
+	}
+}
+
+func TestEncryptStructures(t *testing.T) {
+	c := kv.Encrypt(kv.MsgpackCodec, keyring(t, "old", map[string][]byte{"old": oldKey}))
+	m := kv.NewMemory(kv.MemoryCodec(c))
+
+	if _, err := m.SetAt("set").Add("a"); err != kv.ErrUnsupported {
+		t.Errorf("Set.Add: got %v, want ErrUnsupported", err)
+	}
+	if _, err := m.SetAt("set").Contains("a"); err != kv.ErrUnsupported {
+		t.Errorf("Set.Contains: got %v, want ErrUnsupported", err)
+	}
+	if err := m.SortedSetAt("zset").Add("a", 1); err != kv.ErrUnsupported {
+		t.Errorf("SortedSet.Add: got %v, want ErrUnsupported", err)
+	}
+	if _, err := m.SortedSetAt("zset").Score("a"); err != kv.ErrUnsupported {
+		t.Errorf("SortedSet.Score: got %v, want ErrUnsupported", err)
+	}
+
+	// elements that are not compared still work
+	var s string
+	if err := m.HashAt("hash").Set("f", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HashAt("hash").Get("f", &s); err != nil || s != "v" {
+		t.Errorf("Hash.Get: got %q, %v", s, err)
+	}
+	if err := m.ListAt("list").PushBack("v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ListAt("list").PopFront(&s); err != nil || s != "v" {
+		t.Errorf("List.PopFront: got %q, %v", s, err)
+	}
+
+	// Compress on its own encodes deterministically
+	plain := kv.NewMemory(kv.MemoryCodec(kv.Compress(kv.MsgpackCodec, 64)))
+	if _, err := plain.SetAt("set").Add("a"); err != nil {
+		t.Errorf("Set.Add under Compress: %v", err)
+	}
+}
+
+func TestEncryptCompareAndSwap(t *testing.T) {
+	c := kv.Encrypt(kv.MsgpackCodec, keyring(t, "old", map[string][]byte{"old": oldKey}))
+	r := newRedis(t, kv.UseCodec(c))
+	if err := r.Set("encrypt:cas", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Del("encrypt:cas")
+	if _, err := r.CompareAndSwap("encrypt:cas", "a", "b", time.Minute); err != kv.ErrUnsupported {
+		t.Errorf("CompareAndSwap: got %v, want ErrUnsupported", err)
+	}
+}
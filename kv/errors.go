@@ -1,5 +1,48 @@
 package kv
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrKeyMiss = errors.New("cache: key is missing")
+var ErrTypeMismatch = errors.New("cache: value does not fit the target")
+var ErrInvalidTarget = errors.New("cache: target must be a non-nil pointer")
+var ErrLoaderPanic = errors.New("cache: loader panicked")
+var ErrNotFound = errors.New("cache: value is known not to exist")
+var ErrBatchLength = errors.New("cache: keys and objects differ in length")
+var ErrNotInteger = errors.New("cache: value is not an integer")
+var ErrLockHeld = errors.New("cache: lock is already held")
+var ErrLockNotHeld = errors.New("cache: lock is not held")
+var ErrLockTTL = errors.New("cache: lock ttl must be at least 1ms")
+var ErrRateCount = errors.New("cache: rate limited request count must be positive")
+var ErrCorruptValue = errors.New("cache: stored value is corrupt")
+var ErrNoAddr = errors.New("cache: redis address is missing")
+var ErrNoNode = errors.New("cache: no healthy redis node available")
+var ErrTimeout = errors.New("cache: operation timed out")
+var ErrUnsupported = errors.New("cache: operation not supported by the store")
+var ErrWrongType = errors.New("cache: key holds a different kind of value")
+var ErrClosed = errors.New("cache: store is closed")
+var ErrDiskCorrupt = errors.New("cache: disk log is corrupt")
+var ErrSnapshotCorrupt = errors.New("cache: snapshot is corrupt")
+var ErrSnapshotVersion = errors.New("cache: snapshot version is not supported")
+
+// DecodeError reports a stored value that could not be decoded, typically
+// because it was written with an older shape of the type.
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cache: decode %s: %v", e.Key, e.Err)
+}
+
+// IsErrDecode reports whether err means a stored value does not fit the
+// target, either as a DecodeError or ErrTypeMismatch.
+func IsErrDecode(err error) bool {
+	if _, ok := err.(*DecodeError); ok {
+		return true
+	}
+	return err == ErrTypeMismatch
+}
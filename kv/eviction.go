@@ -0,0 +1,99 @@
+package kv
+
+import "container/heap"
+
+// policy orders the entries of a Memory for eviction.
+type policy interface {
+	add(e *entry)
+	touch(e *entry)
+	remove(e *entry)
+	// victim returns the entry to evict next, or nil if there is none.
+	victim() *entry
+}
+
+// lru keeps entries in a heap ordered by last use. A heap rather than a list
+// lets lru and lfu share the same bookkeeping.
+type lru struct {
+	entries entryHeap
+	clock   uint64
+}
+
+func newLRU() policy {
+	return &lru{entries: entryHeap{less: func(a, b *entry) bool {
+		return a.seq < b.seq
+	}}}
+}
+
+func (p *lru) add(e *entry) {
+	p.clock++
+	e.seq = p.clock
+	heap.Push(&p.entries, e)
+}
+
+func (p *lru) touch(e *entry) {
+	p.clock++
+	e.seq = p.clock
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lru) remove(e *entry) {
+	heap.Remove(&p.entries, e.index)
+}
+
+func (p *lru) victim() *entry {
+	return p.entries.peek()
+}
+
+// lfu orders entries by use count, then by last use.
+type lfu struct {
+	lru
+}
+
+func newLFU() policy {
+	return &lfu{lru{entries: entryHeap{less: func(a, b *entry) bool {
+		if a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.seq < b.seq
+	}}}}
+}
+
+func (p *lfu) touch(e *entry) {
+	e.hits++
+	p.lru.touch(e)
+}
+
+type entryHeap struct {
+	items []*entry
+	less  func(a, b *entry) bool
+}
+
+func (h entryHeap) Len() int           { return len(h.items) }
+func (h entryHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h entryHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(h.items)
+	h.items = append(h.items, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	n := len(h.items)
+	e := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	return e
+}
+
+func (h entryHeap) peek() *entry {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return h.items[0]
+}
@@ -0,0 +1,137 @@
+package kv
+
+import (
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Expirer is implemented by stores that can inspect and change the
+// expiration of a key without rewriting its value. All methods return
+// ErrKeyMiss if key is absent.
+//
+// TTL returns the time key has left, or 0 if it never expires. Expire
+// gives key a new ttl counted from now; like Set, a ttl <= 0 removes the
+// expiration. Persist removes the expiration.
+type Expirer interface {
+	TTL(key string) (time.Duration, error)
+	Expire(key string, ttl time.Duration) error
+	Persist(key string) error
+}
+
+var (
+	_ Expirer = (*Memory)(nil)
+	_ Expirer = (*Redis)(nil)
+)
+
+func (m *Memory) TTL(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	e, found := m.lookup(key)
+	if !found {
+		return 0, ErrKeyMiss
+	}
+	if e.expires == 0 {
+		return 0, nil
+	}
+	return time.Duration(e.expires - time.Now().UnixNano()), nil
+}
+
+func (m *Memory) Expire(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.unlock()
+	e, found := m.lookup(key)
+	if !found {
+		return ErrKeyMiss
+	}
+	e.expires = deadline(ttl)
+	return nil
+}
+
+func (m *Memory) Persist(key string) error {
+	return m.Expire(key, 0)
+}
+
+var persistScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('PERSIST', KEYS[1])
+return 1
+`)
+
+func (r *Redis) TTL(key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return fromPTTL(ttl)
+}
+
+// getBytes reads the encoded value of key along with its TTL as TTL
+// returns it, in the same round trip. The TTL is negative if key expired
+// right after it was read.
+func (r *Redis) getBytes(key string) ([]byte, time.Duration, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		get = pipe.Get(key)
+		pttl = pipe.PTTL(key)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, 0, wrongType(err)
+	}
+	b, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, 0, ErrKeyMiss
+	}
+	if err != nil {
+		return nil, 0, wrongType(err)
+	}
+	ttl, err := fromPTTL(pttl.Val())
+	if err == ErrKeyMiss {
+		return b, -1, nil
+	}
+	return b, ttl, err
+}
+
+// fromPTTL converts a PTTL reply, which is -2 for a missing key and -1 for
+// one without expiration.
+func fromPTTL(ttl time.Duration) (time.Duration, error) {
+	switch ttl {
+	case -2 * time.Millisecond:
+		return 0, ErrKeyMiss
+	case -1 * time.Millisecond:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *Redis) Expire(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return r.Persist(key)
+	}
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	ok, err := r.client.PExpire(key, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyMiss
+	}
+	return nil
+}
+
+func (r *Redis) Persist(key string) error {
+	n, err := runInt(persistScript, r.client, []string{key})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrKeyMiss
+	}
+	return nil
+}
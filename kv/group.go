@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xtimeline/gox/log"
+)
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// group coalesces concurrent calls sharing a key into a single execution.
+type group struct {
+	mu    sync.Mutex
+	calls map[interface{}]*call
+}
+
+func (g *group) do(key interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	c, leader := g.join(key)
+	if !leader {
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	defer g.finish(key, c)
+	c.val, c.err = fn()
+	return c.val, c.err
+}
+
+// start runs fn in the background unless a call for key is already in
+// flight. Nobody waits for the result, so a panic in fn is logged rather
+// than crashing the process.
+func (g *group) start(key interface{}, fn func() (interface{}, error)) {
+	c, leader := g.join(key)
+	if !leader {
+		return
+	}
+	go func() {
+		defer g.finish(key, c)
+		defer func() {
+			if r := recover(); r != nil {
+				l.PithyWarn("kv background load panicked", map[string]interface{}{
+					"key":   fmt.Sprint(key),
+					"panic": fmt.Sprint(r),
+				})
+			}
+		}()
+		c.val, c.err = fn()
+	}()
+}
+
+// join returns the call in flight for key, or registers a new one and
+// reports that the caller leads it.
+func (g *group) join(key interface{}) (*call, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls == nil {
+		g.calls = make(map[interface{}]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	// err is overwritten when fn returns; waiters only see it if fn panics
+	c := &call{err: ErrLoaderPanic}
+	c.wg.Add(1)
+	g.calls[key] = c
+	return c, true
+}
+
+func (g *group) finish(key interface{}, c *call) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.wg.Done()
+}
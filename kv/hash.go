@@ -0,0 +1,204 @@
+package kv
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hash maps fields to values under one key. Get returns ErrKeyMiss for a
+// missing field. Counters created by Incr are kept as decimal integers and
+// are read back with Incr(field, 0).
+type Hash interface {
+	Get(field string, o interface{}) error
+	Set(field string, o interface{}) error
+	Del(fields ...string) error
+	Exists(field string) (bool, error)
+	Fields() ([]string, error)
+	Len() (int64, error)
+	Incr(field string, delta int64) (int64, error)
+}
+
+type redisHash struct {
+	r   *Redis
+	key string
+}
+
+func (r *Redis) HashAt(key string) Hash {
+	return &redisHash{r: r, key: key}
+}
+
+func (h *redisHash) Get(field string, o interface{}) error {
+	b, err := h.r.client.HGet(h.key, field).Bytes()
+	return decodeOne(h.r.codec, h.key, b, err, o)
+}
+
+func (h *redisHash) Set(field string, o interface{}) error {
+	b, err := h.r.codec.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return wrongType(h.r.client.HSet(h.key, field, b).Err())
+}
+
+func (h *redisHash) Del(fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return wrongType(h.r.client.HDel(h.key, fields...).Err())
+}
+
+func (h *redisHash) Exists(field string) (bool, error) {
+	ok, err := h.r.client.HExists(h.key, field).Result()
+	return ok, wrongType(err)
+}
+
+func (h *redisHash) Fields() ([]string, error) {
+	fields, err := h.r.client.HKeys(h.key).Result()
+	return fields, wrongType(err)
+}
+
+func (h *redisHash) Len() (int64, error) {
+	n, err := h.r.client.HLen(h.key).Result()
+	return n, wrongType(err)
+}
+
+func (h *redisHash) Incr(field string, delta int64) (int64, error) {
+	n, err := h.r.client.HIncrBy(h.key, field, delta).Result()
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return 0, ErrNotInteger
+	}
+	return n, wrongType(err)
+}
+
+type memHash struct {
+	fields map[string][]byte
+}
+
+type memoryHash struct {
+	m   *memory
+	key string
+}
+
+func (m *Memory) HashAt(key string) Hash {
+	return &memoryHash{m: m.memory, key: key}
+}
+
+func (h *memoryHash) Get(field string, o interface{}) error {
+	h.m.mu.Lock()
+	e, err := h.m.structure(h.key, (*memHash)(nil))
+	var b []byte
+	var found bool
+	if e != nil {
+		b, found = e.value.(*memHash).fields[field]
+	}
+	h.m.unlock()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyMiss
+	}
+	if err := h.m.opts.codec.Unmarshal(b, o); err != nil {
+		return &DecodeError{Key: h.key, Err: err}
+	}
+	return nil
+}
+
+func (h *memoryHash) Set(field string, o interface{}) error {
+	b, err := h.m.opts.codec.Marshal(o)
+	if err != nil {
+		return err
+	}
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	e, v, err := h.load()
+	if err != nil {
+		return err
+	}
+	v.fields[field] = b
+	h.m.save(h.key, e, v, false)
+	return nil
+}
+
+func (h *memoryHash) Del(fields ...string) error {
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	e, v, err := h.load()
+	if err != nil || e == nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(v.fields, field)
+	}
+	h.m.save(h.key, e, v, len(v.fields) == 0)
+	return nil
+}
+
+func (h *memoryHash) Exists(field string) (bool, error) {
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	_, v, err := h.load()
+	if err != nil {
+		return false, err
+	}
+	_, found := v.fields[field]
+	return found, nil
+}
+
+// Fields returns the fields in sorted order.
+func (h *memoryHash) Fields() ([]string, error) {
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	_, v, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(v.fields))
+	for field := range v.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func (h *memoryHash) Len() (int64, error) {
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	_, v, err := h.load()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(v.fields)), nil
+}
+
+func (h *memoryHash) Incr(field string, delta int64) (int64, error) {
+	h.m.mu.Lock()
+	defer h.m.unlock()
+	e, v, err := h.load()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if b, found := v.fields[field]; found {
+		if n, err = strconv.ParseInt(string(b), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	n += delta
+	v.fields[field] = []byte(strconv.FormatInt(n, 10))
+	h.m.save(h.key, e, v, false)
+	return n, nil
+}
+
+// load returns the hash at key, or a new empty one with a nil entry.
+func (h *memoryHash) load() (*entry, *memHash, error) {
+	e, err := h.m.structure(h.key, (*memHash)(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		return nil, &memHash{fields: make(map[string][]byte)}, nil
+	}
+	return e, e.value.(*memHash), nil
+}
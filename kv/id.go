@@ -0,0 +1,14 @@
+package kv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,303 @@
+// Package kvtest is a conformance suite for kv.Store implementations.
+//
+// A backend runs it from its own tests:
+//
+//	func TestStore(t *testing.T) {
+//		kvtest.Run(t, func() kv.Store { return NewMyStore() })
+//	}
+package kvtest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+type Value struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+// Run checks that the stores returned by newStore follow the kv.Store
+// semantics. Keys are prefixed per run so a shared backend such as Redis
+// can be used.
+func Run(t *testing.T, newStore func() kv.Store) {
+	prefix := "kvtest:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"
+	key := func(name string) string {
+		return prefix + name
+	}
+
+	t.Run("GetMiss", func(t *testing.T) {
+		s := newStore()
+		var v Value
+		if err := s.Get(key("missing"), &v); err != kv.ErrKeyMiss {
+			t.Fatalf("Get of missing key: got %v, want ErrKeyMiss", err)
+		}
+	})
+
+	t.Run("SetGet", func(t *testing.T) {
+		s := newStore()
+		want := Value{Name: "a", Count: 3, Tags: []string{"x", "y"}}
+		if err := s.Set(key("set-get"), want, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var got Value
+		if err := s.Get(key("set-get"), &got); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Scalars", func(t *testing.T) {
+		s := newStore()
+		if err := s.Set(key("string"), "hello", time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var str string
+		if err := s.Get(key("string"), &str); err != nil || str != "hello" {
+			t.Fatalf("Get: got %q, %v; want %q", str, err, "hello")
+		}
+		if err := s.Set(key("int64"), int64(42), time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var i int64
+		if err := s.Get(key("int64"), &i); err != nil || i != 42 {
+			t.Fatalf("Get: got %d, %v; want 42", i, err)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		s := newStore()
+		if err := s.Set(key("overwrite"), Value{Name: "old"}, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := s.Set(key("overwrite"), Value{Name: "new"}, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var got Value
+		if err := s.Get(key("overwrite"), &got); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Name != "new" {
+			t.Fatalf("Get: got %q, want %q", got.Name, "new")
+		}
+	})
+
+	t.Run("Del", func(t *testing.T) {
+		s := newStore()
+		if err := s.Set(key("del"), Value{Name: "a"}, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := s.Del(key("del")); err != nil {
+			t.Fatalf("Del: %v", err)
+		}
+		var v Value
+		if err := s.Get(key("del"), &v); err != kv.ErrKeyMiss {
+			t.Fatalf("Get after Del: got %v, want ErrKeyMiss", err)
+		}
+		if err := s.Del(key("del")); err != nil {
+			t.Fatalf("Del of missing key: %v", err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		s := newStore()
+		if err := s.Set(key("ttl"), Value{Name: "a"}, time.Second); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := s.Set(key("no-ttl"), Value{Name: "b"}, 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var v Value
+		if err := s.Get(key("ttl"), &v); err != nil {
+			t.Fatalf("Get before expiry: %v", err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		if err := s.Get(key("ttl"), &v); err != kv.ErrKeyMiss {
+			t.Fatalf("Get after expiry: got %v, want ErrKeyMiss", err)
+		}
+		if err := s.Get(key("no-ttl"), &v); err != nil {
+			t.Fatalf("Get of entry without ttl: %v", err)
+		}
+		s.Del(key("no-ttl"))
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		s := newStore()
+		b, ok := s.(kv.Batcher)
+		if !ok {
+			t.Skip("store does not implement kv.Batcher")
+		}
+		keys := []string{key("batch-a"), key("batch-b"), key("{batch}c")}
+		objs := []interface{}{Value{Name: "a"}, Value{Name: "b"}, Value{Name: "c"}}
+		if err := b.MSet(keys[:2], objs[:2], time.Minute); err != nil {
+			t.Fatalf("MSet: %v", err)
+		}
+		got := make([]Value, len(keys))
+		found, err := b.MGet(keys, []interface{}{&got[0], &got[1], &got[2]})
+		if err != nil {
+			t.Fatalf("MGet: %v", err)
+		}
+		if !reflect.DeepEqual(found, []bool{true, true, false}) {
+			t.Fatalf("MGet: found %v, want [true true false]", found)
+		}
+		if got[0].Name != "a" || got[1].Name != "b" {
+			t.Fatalf("MGet: got %+v", got)
+		}
+		if err := b.MDel(keys...); err != nil {
+			t.Fatalf("MDel: %v", err)
+		}
+		var v Value
+		if err := s.Get(keys[0], &v); err != kv.ErrKeyMiss {
+			t.Fatalf("Get after MDel: got %v, want ErrKeyMiss", err)
+		}
+		if _, err := b.MGet(keys, nil); err != kv.ErrBatchLength {
+			t.Fatalf("MGet with mismatched lengths: got %v, want ErrBatchLength", err)
+		}
+	})
+
+	t.Run("Counter", func(t *testing.T) {
+		s := newStore()
+		c, ok := s.(kv.Counter)
+		if !ok {
+			t.Skip("store does not implement kv.Counter")
+		}
+		if n, err := c.Incr(key("counter"), 2, time.Minute); err != nil || n != 2 {
+			t.Fatalf("Incr of missing key: got %d, %v; want 2", n, err)
+		}
+		if n, err := c.Incr(key("counter"), 3, time.Minute); err != nil || n != 5 {
+			t.Fatalf("Incr: got %d, %v; want 5", n, err)
+		}
+		if n, err := c.Decr(key("counter"), 1, time.Minute); err != nil || n != 4 {
+			t.Fatalf("Decr: got %d, %v; want 4", n, err)
+		}
+		var n int64
+		if err := s.Get(key("counter"), &n); err != kv.ErrWrongType {
+			t.Fatalf("Get of counter: got %d, %v; want ErrWrongType", n, err)
+		}
+		if err := s.Set(key("not-counter"), Value{Name: "a"}, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if _, err := c.Incr(key("not-counter"), 1, time.Minute); err != kv.ErrNotInteger {
+			t.Fatalf("Incr of non-integer: got %v, want ErrNotInteger", err)
+		}
+		if _, err := c.Incr(key("counter-ttl"), 1, time.Second); err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		if n, err := c.Incr(key("counter-ttl"), 1, time.Second); err != nil || n != 1 {
+			t.Fatalf("Incr after expiry: got %d, %v; want 1", n, err)
+		}
+		s.Del(key("counter"))
+	})
+
+	t.Run("Conditional", func(t *testing.T) {
+		s := newStore()
+		c, ok := s.(kv.Conditional)
+		if !ok {
+			t.Skip("store does not implement kv.Conditional")
+		}
+		if ok, err := c.SetNX(key("setnx"), Value{Name: "a"}, time.Minute); err != nil || !ok {
+			t.Fatalf("SetNX of missing key: got %v, %v; want true", ok, err)
+		}
+		if ok, err := c.SetNX(key("setnx"), Value{Name: "b"}, time.Minute); err != nil || ok {
+			t.Fatalf("SetNX of present key: got %v, %v; want false", ok, err)
+		}
+		if _, err := c.CompareAndSwap(key("cas-missing"), Value{}, Value{}, time.Minute); err != kv.ErrKeyMiss {
+			t.Fatalf("CompareAndSwap of missing key: got %v, want ErrKeyMiss", err)
+		}
+		if ok, err := c.CompareAndSwap(key("setnx"), Value{Name: "x"}, Value{Name: "c"}, time.Minute); err != nil || ok {
+			t.Fatalf("CompareAndSwap with wrong old: got %v, %v; want false", ok, err)
+		}
+		if ok, err := c.CompareAndSwap(key("setnx"), Value{Name: "a"}, Value{Name: "c"}, time.Minute); err != nil || !ok {
+			t.Fatalf("CompareAndSwap: got %v, %v; want true", ok, err)
+		}
+		var v Value
+		if err := s.Get(key("setnx"), &v); err != nil || v.Name != "c" {
+			t.Fatalf("Get after CompareAndSwap: got %+v, %v", v, err)
+		}
+		s.Del(key("setnx"))
+	})
+
+	t.Run("Expirer", func(t *testing.T) {
+		s := newStore()
+		x, ok := s.(kv.Expirer)
+		if !ok {
+			t.Skip("store does not implement kv.Expirer")
+		}
+		if _, err := x.TTL(key("expire-missing")); err != kv.ErrKeyMiss {
+			t.Fatalf("TTL of missing key: got %v, want ErrKeyMiss", err)
+		}
+		if err := x.Expire(key("expire-missing"), time.Minute); err != kv.ErrKeyMiss {
+			t.Fatalf("Expire of missing key: got %v, want ErrKeyMiss", err)
+		}
+		if err := x.Persist(key("expire-missing")); err != kv.ErrKeyMiss {
+			t.Fatalf("Persist of missing key: got %v, want ErrKeyMiss", err)
+		}
+		if err := s.Set(key("expire"), Value{Name: "a"}, 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if ttl, err := x.TTL(key("expire")); err != nil || ttl != 0 {
+			t.Fatalf("TTL of key without expiration: got %v, %v; want 0", ttl, err)
+		}
+		if err := x.Expire(key("expire"), time.Minute); err != nil {
+			t.Fatalf("Expire: %v", err)
+		}
+		if ttl, err := x.TTL(key("expire")); err != nil || ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("TTL after Expire: got %v, %v", ttl, err)
+		}
+		if err := x.Persist(key("expire")); err != nil {
+			t.Fatalf("Persist: %v", err)
+		}
+		if ttl, err := x.TTL(key("expire")); err != nil || ttl != 0 {
+			t.Fatalf("TTL after Persist: got %v, %v; want 0", ttl, err)
+		}
+		if err := x.Expire(key("expire"), time.Second); err != nil {
+			t.Fatalf("Expire: %v", err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		var v Value
+		if err := s.Get(key("expire"), &v); err != kv.ErrKeyMiss {
+			t.Fatalf("Get after expiry: got %v, want ErrKeyMiss", err)
+		}
+	})
+
+	t.Run("Locker", func(t *testing.T) {
+		s := newStore()
+		l, ok := s.(kv.Locker)
+		if !ok {
+			t.Skip("store does not implement kv.Locker")
+		}
+		name := key("lock")
+		first, err := l.AcquireLock(name, "a", time.Minute)
+		if err != nil || first == 0 {
+			t.Fatalf("AcquireLock of free lock: got %d, %v", first, err)
+		}
+		if token, err := l.AcquireLock(name, "b", time.Minute); err != nil || token != 0 {
+			t.Fatalf("AcquireLock of held lock: got %d, %v; want 0", token, err)
+		}
+		if ok, err := l.RenewLock(name, "b", time.Minute); err != nil || ok {
+			t.Fatalf("RenewLock by other owner: got %v, %v; want false", ok, err)
+		}
+		if ok, err := l.ReleaseLock(name, "b"); err != nil || ok {
+			t.Fatalf("ReleaseLock by other owner: got %v, %v; want false", ok, err)
+		}
+		if ok, err := l.RenewLock(name, "a", time.Minute); err != nil || !ok {
+			t.Fatalf("RenewLock: got %v, %v; want true", ok, err)
+		}
+		if ok, err := l.ReleaseLock(name, "a"); err != nil || !ok {
+			t.Fatalf("ReleaseLock: got %v, %v; want true", ok, err)
+		}
+		second, err := l.AcquireLock(name, "b", time.Minute)
+		if err != nil || second <= first {
+			t.Fatalf("AcquireLock after release: got %d, %v; want more than %d", second, err, first)
+		}
+		l.ReleaseLock(name, "b")
+	})
+}
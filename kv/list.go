@@ -0,0 +1,213 @@
+package kv
+
+// List is a sequence of values under one key, usable as a queue or a stack.
+// Like LPUSH, PushFront inserts objs one after the other, so the last of
+// them ends up first. The Pop methods return ErrKeyMiss on an empty list.
+// Range decodes into the slice objs points to.
+type List interface {
+	PushFront(objs ...interface{}) error
+	PushBack(objs ...interface{}) error
+	PopFront(o interface{}) error
+	PopBack(o interface{}) error
+	Range(start, stop int64, objs interface{}) error
+	Trim(start, stop int64) error
+	Len() (int64, error)
+}
+
+type redisList struct {
+	r   *Redis
+	key string
+}
+
+func (r *Redis) ListAt(key string) List {
+	return &redisList{r: r, key: key}
+}
+
+func (l *redisList) PushFront(objs ...interface{}) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	args, err := encodeAll(l.r.codec, objs)
+	if err != nil {
+		return err
+	}
+	return wrongType(l.r.client.LPush(l.key, args...).Err())
+}
+
+func (l *redisList) PushBack(objs ...interface{}) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	args, err := encodeAll(l.r.codec, objs)
+	if err != nil {
+		return err
+	}
+	return wrongType(l.r.client.RPush(l.key, args...).Err())
+}
+
+func (l *redisList) PopFront(o interface{}) error {
+	b, err := l.r.client.LPop(l.key).Bytes()
+	return decodeOne(l.r.codec, l.key, b, err, o)
+}
+
+func (l *redisList) PopBack(o interface{}) error {
+	b, err := l.r.client.RPop(l.key).Bytes()
+	return decodeOne(l.r.codec, l.key, b, err, o)
+}
+
+func (l *redisList) Range(start, stop int64, objs interface{}) error {
+	raws, err := l.r.client.LRange(l.key, start, stop).Result()
+	if err != nil {
+		return wrongType(err)
+	}
+	return decodeAll(l.r.codec, l.key, raws, objs)
+}
+
+func (l *redisList) Trim(start, stop int64) error {
+	return wrongType(l.r.client.LTrim(l.key, start, stop).Err())
+}
+
+func (l *redisList) Len() (int64, error) {
+	n, err := l.r.client.LLen(l.key).Result()
+	return n, wrongType(err)
+}
+
+type memList struct {
+	items [][]byte
+}
+
+type memoryList struct {
+	m   *memory
+	key string
+}
+
+func (m *Memory) ListAt(key string) List {
+	return &memoryList{m: m.memory, key: key}
+}
+
+func (l *memoryList) PushFront(objs ...interface{}) error {
+	return l.push(objs, true)
+}
+
+func (l *memoryList) PushBack(objs ...interface{}) error {
+	return l.push(objs, false)
+}
+
+func (l *memoryList) PopFront(o interface{}) error {
+	return l.pop(o, true)
+}
+
+func (l *memoryList) PopBack(o interface{}) error {
+	return l.pop(o, false)
+}
+
+func (l *memoryList) Range(start, stop int64, objs interface{}) error {
+	l.m.mu.Lock()
+	_, v, err := l.load()
+	var raws []string
+	if err == nil {
+		from, to := span(start, stop, len(v.items))
+		raws = make([]string, 0, to-from)
+		for _, b := range v.items[from:to] {
+			raws = append(raws, string(b))
+		}
+	}
+	l.m.unlock()
+	if err != nil {
+		return err
+	}
+	return decodeAll(l.m.opts.codec, l.key, raws, objs)
+}
+
+func (l *memoryList) Trim(start, stop int64) error {
+	l.m.mu.Lock()
+	defer l.m.unlock()
+	e, v, err := l.load()
+	if err != nil || e == nil {
+		return err
+	}
+	from, to := span(start, stop, len(v.items))
+	v.items = append([][]byte(nil), v.items[from:to]...)
+	l.m.save(l.key, e, v, len(v.items) == 0)
+	return nil
+}
+
+func (l *memoryList) Len() (int64, error) {
+	l.m.mu.Lock()
+	defer l.m.unlock()
+	_, v, err := l.load()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(v.items)), nil
+}
+
+func (l *memoryList) push(objs []interface{}, front bool) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	items := make([][]byte, len(objs))
+	for i, o := range objs {
+		b, err := l.m.opts.codec.Marshal(o)
+		if err != nil {
+			return err
+		}
+		if front {
+			items[len(objs)-1-i] = b
+		} else {
+			items[i] = b
+		}
+	}
+
+	l.m.mu.Lock()
+	defer l.m.unlock()
+	e, v, err := l.load()
+	if err != nil {
+		return err
+	}
+	if front {
+		v.items = append(items, v.items...)
+	} else {
+		v.items = append(v.items, items...)
+	}
+	l.m.save(l.key, e, v, false)
+	return nil
+}
+
+func (l *memoryList) pop(o interface{}, front bool) error {
+	l.m.mu.Lock()
+	e, v, err := l.load()
+	var b []byte
+	found := err == nil && len(v.items) > 0
+	if found {
+		if front {
+			b, v.items = v.items[0], v.items[1:]
+		} else {
+			b, v.items = v.items[len(v.items)-1], v.items[:len(v.items)-1]
+		}
+		l.m.save(l.key, e, v, len(v.items) == 0)
+	}
+	l.m.unlock()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyMiss
+	}
+	if err := l.m.opts.codec.Unmarshal(b, o); err != nil {
+		return &DecodeError{Key: l.key, Err: err}
+	}
+	return nil
+}
+
+// load returns the list at key, or a new empty one with a nil entry.
+func (l *memoryList) load() (*entry, *memList, error) {
+	e, err := l.m.structure(l.key, (*memList)(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		return nil, &memList{}, nil
+	}
+	return e, e.value.(*memList), nil
+}
@@ -0,0 +1,103 @@
+package kv
+
+import "time"
+
+type LoadFunc func() (interface{}, error)
+
+type loadKey struct {
+	store Store
+	key   string
+}
+
+var loads group
+
+// GetOrLoad reads key from s into o. On ErrKeyMiss it calls load, stores the
+// result in s for ttl and fills o with it. Concurrent misses for the same
+// store and key within the process share a single load call and its result.
+//
+// o is filled even when storing the loaded value fails; the Set error is
+// still returned.
+func GetOrLoad(s Store, key string, o interface{}, ttl time.Duration, load LoadFunc) error {
+	err := s.Get(key, o)
+	if err != ErrKeyMiss {
+		return err
+	}
+	return loadInto(s, key, o, ttl, 0, false, load)
+}
+
+// GetOrLoadNegative is GetOrLoad remembering absence as well. When load
+// returns ErrNotFound, a negative entry is stored for negTTL and calls
+// return ErrNotFound without calling load until it expires. A negTTL <= 0
+// stores no negative entry, since it would never expire.
+func GetOrLoadNegative(s Store, key string, o interface{}, ttl, negTTL time.Duration, load LoadFunc) error {
+	err := GetOrNotFound(s, key, o)
+	if err != ErrKeyMiss {
+		return err
+	}
+	return loadInto(s, key, o, ttl, negTTL, true, load)
+}
+
+// SetNotFound records that key does not exist for ttl, removing its value.
+// The negative entry is kept beside the key, so a value written with Set
+// later takes precedence over it while it lasts. Values stored by
+// GetOrLoadNegative remove the negative entry; after GetOrLoad or a plain
+// Set, remove it with ClearNotFound so it does not resurface once the value
+// expires.
+func SetNotFound(s Store, key string, ttl time.Duration) error {
+	if err := s.Del(key); err != nil {
+		return err
+	}
+	return s.Set(notFoundKey(key), true, ttl)
+}
+
+// GetOrNotFound reads key from s into o like Get, but returns ErrNotFound
+// instead of ErrKeyMiss if a negative entry for key is present.
+func GetOrNotFound(s Store, key string, o interface{}) error {
+	err := s.Get(key, o)
+	if err != ErrKeyMiss {
+		return err
+	}
+	var absent bool
+	switch err := s.Get(notFoundKey(key), &absent); err {
+	case nil:
+		return ErrNotFound
+	case ErrKeyMiss:
+		return ErrKeyMiss
+	default:
+		return err
+	}
+}
+
+// ClearNotFound removes the negative entry of key.
+func ClearNotFound(s Store, key string) error {
+	return s.Del(notFoundKey(key))
+}
+
+func loadInto(s Store, key string, o interface{}, ttl, negTTL time.Duration, negative bool, load LoadFunc) error {
+	var setErr error
+	v, err := loads.do(loadKey{store: s, key: key}, func() (interface{}, error) {
+		v, err := load()
+		if err == ErrNotFound && negative && negTTL > 0 {
+			s.Set(notFoundKey(key), true, negTTL)
+		}
+		if err != nil {
+			return nil, err
+		}
+		setErr = s.Set(key, v, ttl)
+		if setErr == nil && negative {
+			setErr = ClearNotFound(s, key)
+		}
+		return v, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := assign(o, v); err != nil {
+		return err
+	}
+	return setErr
+}
+
+func notFoundKey(key string) string {
+	return key + "#notfound"
+}
@@ -0,0 +1,56 @@
+package kv_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+func TestGetOrLoadNegative(t *testing.T) {
+	m := kv.NewMemory()
+	calls := 0
+	missing := func() (interface{}, error) {
+		calls++
+		return nil, kv.ErrNotFound
+	}
+
+	var s string
+	for i := 0; i < 2; i++ {
+		if err := kv.GetOrLoadNegative(m, "neg", &s, time.Minute, time.Minute, missing); err != kv.ErrNotFound {
+			t.Fatalf("GetOrLoadNegative: got %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("load called %d times, want 1", calls)
+	}
+
+	calls = 0
+	for i := 0; i < 2; i++ {
+		if err := kv.GetOrLoadNegative(m, "zero", &s, time.Minute, 0, missing); err != kv.ErrNotFound {
+			t.Fatalf("GetOrLoadNegative with negTTL 0: got %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("negTTL 0: load called %d times, want 2", calls)
+	}
+	if err := kv.GetOrNotFound(m, "zero", &s); err != kv.ErrKeyMiss {
+		t.Errorf("negTTL 0: GetOrNotFound: got %v, want ErrKeyMiss", err)
+	}
+}
+
+func TestGetOrLoadKeepsNotFound(t *testing.T) {
+	m := kv.NewMemory()
+	if err := kv.SetNotFound(m, "k", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	load := func() (interface{}, error) { return "v", nil }
+	if err := kv.GetOrLoad(m, "k", &s, time.Millisecond, load); err != nil || s != "v" {
+		t.Fatalf("GetOrLoad: got %q, %v", s, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := kv.GetOrNotFound(m, "k", &s); err != kv.ErrNotFound {
+		t.Errorf("GetOrNotFound after the value expired: got %v, want ErrNotFound", err)
+	}
+}
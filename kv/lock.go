@@ -0,0 +1,264 @@
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Locker is implemented by stores that can hold leases on named locks.
+// AcquireLock returns a fencing token, which increases every time the lock
+// changes hands, or 0 if the lock is held by someone else. RenewLock and
+// ReleaseLock only act while owner holds the lock.
+type Locker interface {
+	AcquireLock(name, owner string, ttl time.Duration) (int64, error)
+	RenewLock(name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(name, owner string) (bool, error)
+}
+
+var (
+	_ Locker = (*Memory)(nil)
+	_ Locker = (*Redis)(nil)
+)
+
+const lockRetry = 100 * time.Millisecond
+
+// minLockTTL is the shortest lease a Lock takes. Shorter leases could not be
+// renewed in time and would have the renewer flood the store.
+const minLockTTL = time.Millisecond
+
+// Lock is a lease on a named lock that renews itself in the background
+// until Unlock is called or a renewal is refused.
+type Lock struct {
+	locker Locker
+	name   string
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	owner string
+	token int64
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+func NewLock(locker Locker, name string, ttl time.Duration) *Lock {
+	return &Lock{
+		locker: locker,
+		name:   name,
+		ttl:    ttl,
+	}
+}
+
+// TryLock acquires the lock if it is free and reports whether it did.
+func (l *Lock) TryLock() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner != "" {
+		return false, ErrLockHeld
+	}
+	if l.ttl < minLockTTL {
+		return false, ErrLockTTL
+	}
+
+	owner, err := randomID()
+	if err != nil {
+		return false, err
+	}
+	token, err := l.locker.AcquireLock(l.name, owner, l.ttl)
+	if err != nil || token == 0 {
+		return false, err
+	}
+
+	l.owner = owner
+	l.token = token
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.lost = make(chan struct{})
+	go l.renew(owner, l.stop, l.done, l.lost)
+	return true, nil
+}
+
+// Lock waits until the lock is acquired or ctx is done.
+func (l *Lock) Lock(ctx context.Context) error {
+	for {
+		ok, err := l.TryLock()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+// Unlock stops renewing the lease and releases it. ErrLockNotHeld is
+// returned if the lease was lost in the meantime.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner == "" {
+		return ErrLockNotHeld
+	}
+
+	close(l.stop)
+	<-l.done
+	owner := l.owner
+	l.owner = ""
+	l.token = 0
+
+	ok, err := l.locker.ReleaseLock(l.name, owner)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Token returns the fencing token of the current lease, or 0 if the lock is
+// not held. Pass it to the protected resource so it can reject writes from
+// earlier holders.
+func (l *Lock) Token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.token
+}
+
+// Lost returns a channel that is closed if the current lease could not be
+// renewed. It returns nil if the lock is not held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner == "" {
+		return nil
+	}
+	return l.lost
+}
+
+func (l *Lock) renew(owner string, stop, done, lost chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ok, err := l.locker.RenewLock(l.name, owner, l.ttl)
+		if err == nil && ok {
+			renewed = time.Now()
+			continue
+		}
+		// transient errors are retried for as long as the lease may still
+		// be valid
+		if err == nil || time.Since(renewed) >= l.ttl {
+			close(lost)
+			return
+		}
+	}
+}
+
+func lockKey(name string) string {
+	return "lock:{" + name + "}"
+}
+
+func fenceKey(name string) string {
+	return "lock:{" + name + "}:fence"
+}
+
+type lease struct {
+	owner   string
+	expires int64
+}
+
+// lease returns the unexpired lease on the lock name; mu must be held.
+func (m *memory) lease(name string) (*lease, bool) {
+	l, found := m.locks[name]
+	if !found {
+		return nil, false
+	}
+	if l.expires <= time.Now().UnixNano() {
+		delete(m.locks, name)
+		return nil, false
+	}
+	return l, true
+}
+
+func (m *Memory) AcquireLock(name, owner string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	if _, found := m.lease(name); found {
+		return 0, nil
+	}
+	m.locks[name] = &lease{owner: owner, expires: time.Now().Add(ttl).UnixNano()}
+	m.fences[name]++
+	m.notify(EventSet, lockKey(name))
+	return m.fences[name], nil
+}
+
+func (m *Memory) RenewLock(name, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	l, found := m.lease(name)
+	if !found || l.owner != owner {
+		return false, nil
+	}
+	l.expires = time.Now().Add(ttl).UnixNano()
+	return true, nil
+}
+
+func (m *Memory) ReleaseLock(name, owner string) (bool, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	l, found := m.lease(name)
+	if !found || l.owner != owner {
+		return false, nil
+	}
+	delete(m.locks, name)
+	m.notify(EventDel, lockKey(name))
+	return true, nil
+}
+
+var acquireScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (r *Redis) AcquireLock(name, owner string, ttl time.Duration) (int64, error) {
+	keys := []string{lockKey(name), fenceKey(name)}
+	return runInt(acquireScript, r.client, keys, owner, milliseconds(ttl))
+}
+
+func (r *Redis) RenewLock(name, owner string, ttl time.Duration) (bool, error) {
+	n, err := runInt(renewScript, r.client, []string{lockKey(name)}, owner, milliseconds(ttl))
+	return n == 1, err
+}
+
+func (r *Redis) ReleaseLock(name, owner string) (bool, error) {
+	n, err := runInt(releaseScript, r.client, []string{lockKey(name)}, owner)
+	return n == 1, err
+}
@@ -0,0 +1,206 @@
+package kv
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/xtimeline/gox/json"
+)
+
+var errMemoSignature = errors.New("cache: memoized function must return a value and optionally an error")
+
+type memoOptions struct {
+	name string
+}
+
+type MemoOption func(opts *memoOptions)
+
+// MemoName sets the name keys are derived from. It defaults to the
+// function's symbol name, which changes when the function is renamed or
+// moved and is not meaningful for closures.
+func MemoName(v string) MemoOption {
+	return func(opts *memoOptions) {
+		opts.name = v
+	}
+}
+
+type memoContextKey int
+
+const (
+	memoBypass memoContextKey = iota
+	memoRefresh
+)
+
+// BypassCache makes a memoized call with ctx skip the cache entirely.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memoBypass, true)
+}
+
+// RefreshCache makes a memoized call with ctx call the function and
+// overwrite the cached result.
+func RefreshCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memoRefresh, true)
+}
+
+// memoLoads coalesces memoized calls. It is kept apart from loads, whose
+// callers may use the same keys for values of other types.
+var memoLoads group
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type memo struct {
+	store    Store
+	fn       reflect.Value
+	name     string
+	ttl      time.Duration
+	result   reflect.Type
+	hasErr   bool
+	hasCtx   bool
+	variadic bool
+}
+
+// Memoize sets the function fptr points to to a version of fn caching its
+// results in s for ttl:
+//
+//	var price func(ctx context.Context, sku string, qty int) (float64, error)
+//	err := kv.Memoize(&price, s, lookupPrice, time.Minute)
+//
+// fn must return a value, optionally followed by an error; results are only
+// cached when the error is nil. Keys are derived from the name and the JSON
+// encoding of the arguments, so arguments must encode deterministically.
+// If the first parameter is a context.Context, it is left out of the key
+// and BypassCache and RefreshCache control single calls through it.
+// Concurrent calls with the same arguments share one call of fn; if it
+// panics, the calls waiting for it panic with ErrLoaderPanic or, when fn
+// returns an error, return it.
+func Memoize(fptr interface{}, s Store, fn interface{}, ttl time.Duration, opts ...MemoOption) error {
+	f := reflect.ValueOf(fn)
+	p := reflect.ValueOf(fptr)
+	if f.Kind() != reflect.Func || p.Kind() != reflect.Ptr || p.IsNil() || p.Elem().Type() != f.Type() {
+		return ErrInvalidTarget
+	}
+	t := f.Type()
+	switch {
+	case t.NumOut() == 1 && t.Out(0) != errorType:
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+	default:
+		return errMemoSignature
+	}
+
+	memoOps := memoOptions{
+		name: runtime.FuncForPC(f.Pointer()).Name(),
+	}
+	for _, opt := range opts {
+		opt(&memoOps)
+	}
+
+	m := &memo{
+		store:    s,
+		fn:       f,
+		name:     memoOps.name,
+		ttl:      ttl,
+		result:   t.Out(0),
+		hasErr:   t.NumOut() == 2,
+		hasCtx:   t.NumIn() > 0 && t.In(0) == contextType,
+		variadic: t.IsVariadic(),
+	}
+	p.Elem().Set(reflect.MakeFunc(t, m.call))
+	return nil
+}
+
+func (m *memo) call(args []reflect.Value) []reflect.Value {
+	var bypass, refresh bool
+	if m.hasCtx && !args[0].IsNil() {
+		ctx := args[0].Interface().(context.Context)
+		bypass = ctx.Value(memoBypass) != nil
+		refresh = ctx.Value(memoRefresh) != nil
+	}
+	key, err := m.key(args)
+	if err != nil || bypass {
+		return m.invoke(args)
+	}
+
+	if !refresh {
+		out := reflect.New(m.result)
+		if err := m.store.Get(key, out.Interface()); err == nil {
+			return m.results(out.Elem(), nil)
+		}
+	}
+
+	var results []reflect.Value
+	v, err := memoLoads.do(loadKey{store: m.store, key: key}, func() (interface{}, error) {
+		results = m.invoke(args)
+		if err := m.err(results); err != nil {
+			return nil, err
+		}
+		v := results[0].Interface()
+		m.store.Set(key, v, m.ttl)
+		return v, nil
+	})
+	if results != nil {
+		return results
+	}
+	if err == ErrLoaderPanic && !m.hasErr {
+		// fn has no way to report the failure of the call we waited for
+		panic(err)
+	}
+	if err != nil {
+		return m.results(reflect.Zero(m.result), err)
+	}
+	// v was returned by another call of fn, so it fits the result type
+	out := reflect.New(m.result).Elem()
+	if v != nil {
+		out.Set(reflect.ValueOf(v))
+	}
+	return m.results(out, nil)
+}
+
+func (m *memo) invoke(args []reflect.Value) []reflect.Value {
+	if m.variadic {
+		return m.fn.CallSlice(args)
+	}
+	return m.fn.Call(args)
+}
+
+func (m *memo) err(results []reflect.Value) error {
+	if !m.hasErr || results[1].IsNil() {
+		return nil
+	}
+	return results[1].Interface().(error)
+}
+
+func (m *memo) results(v reflect.Value, err error) []reflect.Value {
+	if !m.hasErr {
+		return []reflect.Value{v}
+	}
+	errv := reflect.Zero(errorType)
+	if err != nil {
+		errv = reflect.ValueOf(&err).Elem()
+	}
+	return []reflect.Value{v, errv}
+}
+
+// key derives the cache key of a call from the name and the arguments.
+func (m *memo) key(args []reflect.Value) (string, error) {
+	if m.hasCtx {
+		args = args[1:]
+	}
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Interface()
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(b)
+	return "memo:" + m.name + ":" + hex.EncodeToString(sum[:]), nil
+}
@@ -1,34 +1,378 @@
 package kv
 
 import (
+	"reflect"
+	"runtime"
+	"sync"
 	"time"
+)
+
+type EvictReason int
 
-	"github.com/patrickmn/go-cache"
+const (
+	// EvictCapacity means the entry was dropped to stay within the size limits.
+	EvictCapacity EvictReason = iota
+	// EvictExpired means the entry outlived its ttl.
+	EvictExpired
 )
 
+type memoryOptions struct {
+	maxEntries int
+	maxBytes   int
+	policy     func() policy
+	sizer      func(v interface{}) int
+	onEvict    func(key string, v interface{}, reason EvictReason)
+	codec      Codec
+	janitor    time.Duration
+}
+
+type MemoryOption func(opts *memoryOptions)
+
+// MaxEntries bounds the number of entries kept in memory.
+func MaxEntries(v int) MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.maxEntries = v
+	}
+}
+
+// MaxBytes bounds the approximate size of the values kept in memory, as
+// measured by the Sizer.
+func MaxBytes(v int) MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.maxBytes = v
+	}
+}
+
+// LRU evicts the least recently used entry first. It is the default.
+func LRU() MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.policy = newLRU
+	}
+}
+
+// LFU evicts the least frequently used entry first, the least recently used
+// among equally frequent ones.
+func LFU() MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.policy = newLFU
+	}
+}
+
+// Sizer replaces the reflection based estimate used by MaxBytes.
+func Sizer(v func(v interface{}) int) MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.sizer = v
+	}
+}
+
+// OnEvict is called after an entry is dropped because of the size limits or
+// its ttl. It runs on the goroutine that triggered the eviction, outside of
+// any lock.
+func OnEvict(v func(key string, v interface{}, reason EvictReason)) MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.onEvict = v
+	}
+}
+
+// MemoryCodec sets how the elements of hashes, lists, sets and sorted sets
+// are encoded, so they compare and decode like their Redis counterparts.
+// Plain values are kept as they are. The default is MsgpackCodec.
+func MemoryCodec(v Codec) MemoryOption {
+	return func(opts *memoryOptions) {
+		opts.codec = v
+	}
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires int64
+	size    int
+
+	// bookkeeping of the eviction policy
+	hits  int
+	seq   uint64
+	index int
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expires > 0 && now >= e.expires
+}
+
+type evicted struct {
+	key    string
+	value  interface{}
+	reason EvictReason
+}
+
+// Memory is an in-process store. Unless limits are set it grows without
+// bound; expired entries are dropped when read or by a periodic sweep.
+// Entries set with a ttl of 0 never expire, there is no default expiration.
 type Memory struct {
-	cache *cache.Cache
+	*memory
+}
+
+type memory struct {
+	opts    memoryOptions
+	mu      sync.Mutex
+	items   map[string]*entry
+	bytes   int
+	policy  policy
+	evicted []evicted
+	hooks   []func(key string, v interface{}, reason EvictReason)
+	watches []*Subscription
+	events  []Event
+	stop    chan struct{}
+	// locks, fences and limiter state live outside items so capacity
+	// eviction can never drop a held lease, reset a fencing token or let
+	// requests past a rate limit
+	locks  map[string]*lease
+	fences map[string]int64
+	limits map[string]*limitState
 }
 
-func NewMemory() *Memory {
-	return &Memory{
-		cache: cache.New(5*time.Minute, 10*time.Minute),
+func NewMemory(opts ...MemoryOption) *Memory {
+	memOps := memoryOptions{
+		policy:  newLRU,
+		sizer:   sizeOf,
+		codec:   MsgpackCodec,
+		janitor: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&memOps)
+	}
+
+	m := &memory{
+		opts:   memOps,
+		items:  make(map[string]*entry),
+		policy: memOps.policy(),
+		locks:  make(map[string]*lease),
+		fences: make(map[string]int64),
+		limits: make(map[string]*limitState),
+		stop:   make(chan struct{}),
 	}
+	go m.sweep()
+	// the sweeper only references memory, so Memory can still be collected
+	// and its finalizer stops the sweeper
+	w := &Memory{m}
+	runtime.SetFinalizer(w, func(w *Memory) {
+		close(w.stop)
+	})
+	return w
 }
 
 func (m *Memory) Set(key string, o interface{}, ttl time.Duration) error {
-	m.cache.Set(key, o, ttl)
+	m.mu.Lock()
+	defer m.unlock()
+	m.set(key, o, ttl)
 	return nil
 }
 
-func (m *Memory) Get(key string) (interface{}, error) {
-	if o, found := m.cache.Get(key); found {
-		return o, nil
+func (m *Memory) Get(key string, o interface{}) error {
+	m.mu.Lock()
+	e, found := m.get(key)
+	var v interface{}
+	if found {
+		v = e.value
+	}
+	m.unlock()
+	if !found {
+		return ErrKeyMiss
+	}
+	switch v.(type) {
+	case counter, *memHash, *memList, *memSet, *memSortedSet:
+		return ErrWrongType
 	}
-	return nil, ErrKeyMiss
+	return assign(o, v)
 }
 
 func (m *Memory) Del(key string) error {
-	m.cache.Delete(key)
+	m.mu.Lock()
+	defer m.unlock()
+	if e, found := m.items[key]; found {
+		m.remove(e)
+		m.notify(EventDel, key)
+	}
 	return nil
 }
+
+// Len returns the number of entries, including expired ones not yet swept.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.unlock()
+	return len(m.items)
+}
+
+// unlock releases mu and reports entries evicted while it was held. Events
+// are handed to the watchers before, so they see changes in order.
+func (m *memory) unlock() {
+	for _, ev := range m.events {
+		for _, w := range m.watches {
+			w.push(ev)
+		}
+	}
+	m.events = nil
+	evicted := m.evicted
+	m.evicted = nil
+	hooks := m.hooks
+	m.mu.Unlock()
+	for _, e := range evicted {
+		if m.opts.onEvict != nil {
+			m.opts.onEvict(e.key, e.value, e.reason)
+		}
+		for _, hook := range hooks {
+			hook(e.key, e.value, e.reason)
+		}
+	}
+}
+
+// observeEvictions registers fn to be called like an OnEvict callback.
+func (m *Memory) observeEvictions(fn func(key string, v interface{}, reason EvictReason)) {
+	m.mu.Lock()
+	defer m.unlock()
+	m.hooks = append(m.hooks, fn)
+}
+
+// lookup returns the live entry of key without counting it as a use.
+func (m *memory) lookup(key string) (*entry, bool) {
+	e, found := m.items[key]
+	if !found {
+		return nil, false
+	}
+	if e.expired(time.Now().UnixNano()) {
+		m.evict(e, EvictExpired)
+		return nil, false
+	}
+	return e, true
+}
+
+func (m *memory) get(key string) (*entry, bool) {
+	e, found := m.lookup(key)
+	if found {
+		m.policy.touch(e)
+	}
+	return e, found
+}
+
+func (m *memory) set(key string, v interface{}, ttl time.Duration) {
+	if e, found := m.items[key]; found {
+		m.remove(e)
+	}
+	e := &entry{
+		key:     key,
+		value:   v,
+		expires: deadline(ttl),
+	}
+	m.notify(EventSet, key)
+	if m.opts.maxBytes > 0 {
+		e.size = len(key) + m.opts.sizer(v)
+		if e.size > m.opts.maxBytes {
+			m.evicted = append(m.evicted, evicted{key: key, value: v, reason: EvictCapacity})
+			m.notify(EventDel, key)
+			return
+		}
+	}
+	// make room before adding, so the new entry is never its own victim
+	m.shrink(1, e.size)
+	m.items[key] = e
+	m.bytes += e.size
+	m.policy.add(e)
+}
+
+// update replaces the value of e in place, keeping its expiration.
+func (m *memory) update(e *entry, v interface{}) {
+	e.value = v
+	m.notify(EventSet, e.key)
+	if m.opts.maxBytes > 0 {
+		m.bytes -= e.size
+		e.size = len(e.key) + m.opts.sizer(v)
+		m.bytes += e.size
+		m.shrink(0, 0)
+	}
+}
+
+func (m *memory) remove(e *entry) {
+	delete(m.items, e.key)
+	m.bytes -= e.size
+	m.policy.remove(e)
+}
+
+func (m *memory) evict(e *entry, reason EvictReason) {
+	m.remove(e)
+	m.evicted = append(m.evicted, evicted{key: e.key, value: e.value, reason: reason})
+	if reason == EvictExpired {
+		m.notify(EventExpire, e.key)
+	} else {
+		m.notify(EventDel, e.key)
+	}
+}
+
+// shrink evicts entries until n more entries of size more bytes fit.
+func (m *memory) shrink(n, size int) {
+	for (m.opts.maxEntries > 0 && len(m.items)+n > m.opts.maxEntries) ||
+		(m.opts.maxBytes > 0 && m.bytes+size > m.opts.maxBytes) {
+		e := m.policy.victim()
+		if e == nil {
+			return
+		}
+		m.evict(e, EvictCapacity)
+	}
+}
+
+func (m *memory) sweep() {
+	ticker := time.NewTicker(m.opts.janitor)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		now := time.Now().UnixNano()
+		for _, e := range m.items {
+			if e.expired(now) {
+				m.evict(e, EvictExpired)
+			}
+		}
+		for key, l := range m.limits {
+			if l.expires <= now {
+				delete(m.limits, key)
+			}
+		}
+		m.unlock()
+	}
+}
+
+// deadline converts ttl to an expiration time; 0 means never.
+func deadline(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// assign copies v into the value o points to. A stored pointer may be read
+// back into a value of its element type, mirroring how the Redis backend
+// decodes into whatever the caller passes.
+func assign(o interface{}, v interface{}) error {
+	dst := reflect.ValueOf(o)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrInvalidTarget
+	}
+	dst = dst.Elem()
+	src := reflect.ValueOf(v)
+	if !src.IsValid() {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if src.Kind() == reflect.Ptr && !src.IsNil() && src.Elem().Type().AssignableTo(dst.Type()) {
+		dst.Set(src.Elem())
+		return nil
+	}
+	return ErrTypeMismatch
+}
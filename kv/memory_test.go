@@ -0,0 +1,114 @@
+package kv_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+	"github.com/xtimeline/gox/kv/kvtest"
+)
+
+func TestMemory(t *testing.T) {
+	kvtest.Run(t, func() kv.Store { return kv.NewMemory() })
+}
+
+func TestMemoryBounded(t *testing.T) {
+	kvtest.Run(t, func() kv.Store {
+		return kv.NewMemory(kv.MaxEntries(1000), kv.LFU())
+	})
+}
+
+type eviction struct {
+	key    string
+	reason kv.EvictReason
+}
+
+func recordEvictions(evictions *[]eviction) kv.MemoryOption {
+	return kv.OnEvict(func(key string, v interface{}, reason kv.EvictReason) {
+		*evictions = append(*evictions, eviction{key, reason})
+	})
+}
+
+func TestMemoryLRU(t *testing.T) {
+	var evictions []eviction
+	m := kv.NewMemory(kv.MaxEntries(3), recordEvictions(&evictions))
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	var n int
+	m.Get("a", &n)
+	m.Set("d", 4, 0)
+	m.Set("e", 5, 0)
+
+	want := []eviction{{"b", kv.EvictCapacity}, {"c", kv.EvictCapacity}}
+	if !reflect.DeepEqual(evictions, want) {
+		t.Fatalf("evictions: got %v, want %v", evictions, want)
+	}
+	for _, key := range []string{"a", "d", "e"} {
+		if err := m.Get(key, &n); err != nil {
+			t.Fatalf("Get %s: %v", key, err)
+		}
+	}
+}
+
+func TestMemoryLFU(t *testing.T) {
+	var evictions []eviction
+	m := kv.NewMemory(kv.MaxEntries(3), kv.LFU(), recordEvictions(&evictions))
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	var n int
+	for i := 0; i < 3; i++ {
+		m.Get("a", &n)
+		m.Get("c", &n)
+	}
+	m.Get("b", &n)
+	// b is the most recently used but the least frequently
+	m.Set("d", 4, 0)
+
+	want := []eviction{{"b", kv.EvictCapacity}}
+	if !reflect.DeepEqual(evictions, want) {
+		t.Fatalf("evictions: got %v, want %v", evictions, want)
+	}
+}
+
+func TestMemoryMaxBytes(t *testing.T) {
+	var evictions []eviction
+	m := kv.NewMemory(
+		kv.MaxBytes(30),
+		kv.Sizer(func(v interface{}) int { return len(v.(string)) }),
+		recordEvictions(&evictions),
+	)
+	m.Set("a", strings.Repeat("x", 10), 0)
+	m.Set("b", strings.Repeat("x", 10), 0)
+	// each entry counts its key too, so a third one does not fit
+	m.Set("c", strings.Repeat("x", 10), 0)
+	// larger than the limit on its own
+	m.Set("d", strings.Repeat("x", 40), 0)
+
+	want := []eviction{{"a", kv.EvictCapacity}, {"d", kv.EvictCapacity}}
+	if !reflect.DeepEqual(evictions, want) {
+		t.Fatalf("evictions: got %v, want %v", evictions, want)
+	}
+	var s string
+	if err := m.Get("d", &s); err != kv.ErrKeyMiss {
+		t.Fatalf("Get of oversized value: got %v, want ErrKeyMiss", err)
+	}
+}
+
+func TestMemoryEvictExpired(t *testing.T) {
+	var evictions []eviction
+	m := kv.NewMemory(recordEvictions(&evictions))
+	m.Set("a", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	var n int
+	if err := m.Get("a", &n); err != kv.ErrKeyMiss {
+		t.Fatalf("Get of expired key: got %v, want ErrKeyMiss", err)
+	}
+	want := []eviction{{"a", kv.EvictExpired}}
+	if !reflect.DeepEqual(evictions, want) {
+		t.Fatalf("evictions: got %v, want %v", evictions, want)
+	}
+}
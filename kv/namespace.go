@@ -0,0 +1,148 @@
+package kv
+
+import (
+	"strconv"
+	"time"
+)
+
+// Namespaced prefixes every key with a name and a schema version, so
+// services sharing a backend cannot collide and bumping the version orphans
+// entries written with an older shape. Entries that fail to decode are
+// treated as misses; they are left in place to be overwritten or expire.
+// ErrTypeMismatch means the target rather than the entry is wrong and is
+// returned as is.
+type Namespaced struct {
+	store  Store
+	prefix string
+}
+
+var (
+	_ Store       = (*Namespaced)(nil)
+	_ Batcher     = (*Namespaced)(nil)
+	_ Counter     = (*Namespaced)(nil)
+	_ Conditional = (*Namespaced)(nil)
+	_ Expirer     = (*Namespaced)(nil)
+)
+
+func Namespace(s Store, name string, version int) *Namespaced {
+	return &Namespaced{
+		store:  s,
+		prefix: name + ":v" + strconv.Itoa(version) + ":",
+	}
+}
+
+// Key returns the key under which key is stored in the wrapped store.
+func (n *Namespaced) Key(key string) string {
+	return n.prefix + key
+}
+
+func (n *Namespaced) Set(key string, o interface{}, ttl time.Duration) error {
+	return n.store.Set(n.Key(key), o, ttl)
+}
+
+func (n *Namespaced) Get(key string, o interface{}) error {
+	err := n.store.Get(n.Key(key), o)
+	if isStale(err) {
+		return ErrKeyMiss
+	}
+	return err
+}
+
+func (n *Namespaced) Del(key string) error {
+	return n.store.Del(n.Key(key))
+}
+
+func (n *Namespaced) MGet(keys []string, objs []interface{}) ([]bool, error) {
+	found, err := mget(n.store, n.keys(keys), objs)
+	if !isStale(err) {
+		return found, err
+	}
+
+	// one entry is stale; fetch them one by one to tell which
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		err := n.Get(key, objs[i])
+		if err == ErrKeyMiss {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+func (n *Namespaced) MSet(keys []string, objs []interface{}, ttl time.Duration) error {
+	return mset(n.store, n.keys(keys), objs, ttl)
+}
+
+func (n *Namespaced) MDel(keys ...string) error {
+	return mdel(n.store, n.keys(keys))
+}
+
+func (n *Namespaced) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, ok := n.store.(Counter)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return counter.Incr(n.Key(key), delta, ttl)
+}
+
+func (n *Namespaced) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return n.Incr(key, -delta, ttl)
+}
+
+func (n *Namespaced) SetNX(key string, o interface{}, ttl time.Duration) (bool, error) {
+	cond, ok := n.store.(Conditional)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return cond.SetNX(n.Key(key), o, ttl)
+}
+
+func (n *Namespaced) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	cond, ok := n.store.(Conditional)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return cond.CompareAndSwap(n.Key(key), old, new, ttl)
+}
+
+func (n *Namespaced) TTL(key string) (time.Duration, error) {
+	x, ok := n.store.(Expirer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return x.TTL(n.Key(key))
+}
+
+func (n *Namespaced) Expire(key string, ttl time.Duration) error {
+	x, ok := n.store.(Expirer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return x.Expire(n.Key(key), ttl)
+}
+
+func (n *Namespaced) Persist(key string) error {
+	x, ok := n.store.(Expirer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return x.Persist(n.Key(key))
+}
+
+func (n *Namespaced) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = n.Key(key)
+	}
+	return prefixed
+}
+
+// isStale reports whether err means a stored value could not be decoded.
+func isStale(err error) bool {
+	_, ok := err.(*DecodeError)
+	return ok
+}
@@ -0,0 +1,319 @@
+package kv
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type Decision struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is how long to wait before the request could be allowed.
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(key string) (Decision, error)
+	AllowN(key string, n int64) (Decision, error)
+}
+
+// RateStore keeps token bucket and sliding window state atomically. It is
+// implemented by Memory for limits local to the process and by Redis for
+// limits shared by replicas, which then rely on their clocks being in sync.
+type RateStore interface {
+	takeTokens(key string, rate float64, burst, n int64, now time.Time) (Decision, error)
+	logEvents(key string, limit int64, window time.Duration, n int64, now time.Time) (Decision, error)
+}
+
+var (
+	errBucketConfig = errors.New("cache: token bucket rate and burst must be positive")
+	errWindowConfig = errors.New("cache: window limit and length must be positive")
+)
+
+var (
+	_ RateStore = (*Memory)(nil)
+	_ RateStore = (*Redis)(nil)
+
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*FixedWindow)(nil)
+	_ Limiter = (*SlidingWindow)(nil)
+)
+
+// TokenBucket allows bursts of up to burst requests, refilled at rate
+// tokens per second.
+type TokenBucket struct {
+	store RateStore
+	rate  float64
+	burst int64
+}
+
+// NewTokenBucket fails unless rate and burst are positive and finite.
+func NewTokenBucket(s RateStore, rate float64, burst int64) (*TokenBucket, error) {
+	if !(rate > 0) || math.IsInf(rate, 1) || burst <= 0 {
+		return nil, errBucketConfig
+	}
+	return &TokenBucket{store: s, rate: rate, burst: burst}, nil
+}
+
+func (b *TokenBucket) Allow(key string) (Decision, error) {
+	return b.AllowN(key, 1)
+}
+
+func (b *TokenBucket) AllowN(key string, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, ErrRateCount
+	}
+	return b.store.takeTokens("rate:tb:"+key, b.rate, b.burst, n, time.Now())
+}
+
+// FixedWindow allows limit requests per window, with windows aligned to the
+// Unix epoch. Denied requests count against the window too. Its counters
+// are ordinary entries, so on a Memory bounded by MaxEntries or MaxBytes
+// they can be evicted; give such limiters a Memory of their own.
+type FixedWindow struct {
+	store  Counter
+	limit  int64
+	window time.Duration
+}
+
+// NewFixedWindow fails unless limit and window are positive.
+func NewFixedWindow(s Counter, limit int64, window time.Duration) (*FixedWindow, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, errWindowConfig
+	}
+	return &FixedWindow{store: s, limit: limit, window: window}, nil
+}
+
+func (w *FixedWindow) Allow(key string) (Decision, error) {
+	return w.AllowN(key, 1)
+}
+
+func (w *FixedWindow) AllowN(key string, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, ErrRateCount
+	}
+	now := time.Now().UnixNano()
+	index := now / int64(w.window)
+	left := time.Duration((index+1)*int64(w.window) - now)
+	count, err := w.store.Incr("rate:fw:"+key+":"+strconv.FormatInt(index, 10), n, left)
+	if err != nil {
+		return Decision{}, err
+	}
+	if count > w.limit {
+		return Decision{RetryAfter: left}, nil
+	}
+	return Decision{Allowed: true, Remaining: w.limit - count}, nil
+}
+
+// SlidingWindow allows limit requests in any window long period. It keeps
+// a timestamp per allowed request, so it suits small limits.
+type SlidingWindow struct {
+	store  RateStore
+	limit  int64
+	window time.Duration
+}
+
+// NewSlidingWindow fails unless limit and window are positive.
+func NewSlidingWindow(s RateStore, limit int64, window time.Duration) (*SlidingWindow, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, errWindowConfig
+	}
+	return &SlidingWindow{store: s, limit: limit, window: window}, nil
+}
+
+func (w *SlidingWindow) Allow(key string) (Decision, error) {
+	return w.AllowN(key, 1)
+}
+
+func (w *SlidingWindow) AllowN(key string, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, ErrRateCount
+	}
+	return w.store.logEvents("rate:sw:"+key, w.limit, w.window, n, time.Now())
+}
+
+type bucketState struct {
+	tokens float64
+	at     time.Time
+}
+
+// limitState holds a bucketState or the []time.Time log of a sliding window.
+type limitState struct {
+	value   interface{}
+	expires int64
+}
+
+// limit returns the unexpired limiter state at key, or nil; mu must be held.
+func (m *memory) limit(key string) interface{} {
+	l, found := m.limits[key]
+	if !found {
+		return nil
+	}
+	if l.expires <= time.Now().UnixNano() {
+		delete(m.limits, key)
+		return nil
+	}
+	return l.value
+}
+
+func (m *memory) setLimit(key string, v interface{}, ttl time.Duration) {
+	m.limits[key] = &limitState{value: v, expires: time.Now().Add(ttl).UnixNano()}
+}
+
+func (m *Memory) takeTokens(key string, rate float64, burst, n int64, now time.Time) (Decision, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	state := bucketState{tokens: float64(burst), at: now}
+	if s, ok := m.limit(key).(bucketState); ok {
+		state = s
+	}
+	if elapsed := now.Sub(state.at); elapsed > 0 {
+		state.tokens = math.Min(float64(burst), state.tokens+elapsed.Seconds()*rate)
+		state.at = now
+	}
+
+	d := Decision{}
+	if state.tokens >= float64(n) {
+		state.tokens -= float64(n)
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((float64(n) - state.tokens) / rate * float64(time.Second))
+	}
+	d.Remaining = int64(state.tokens)
+	m.setLimit(key, state, refillTime(rate, burst))
+	return d, nil
+}
+
+func (m *Memory) logEvents(key string, limit int64, window time.Duration, n int64, now time.Time) (Decision, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	log, _ := m.limit(key).([]time.Time)
+	start := now.Add(-window)
+	live := log[:0]
+	for _, t := range log {
+		if t.After(start) {
+			live = append(live, t)
+		}
+	}
+	log = live
+
+	count := int64(len(log))
+	if count+n <= limit {
+		for i := int64(0); i < n; i++ {
+			log = append(log, now)
+		}
+		m.setLimit(key, log, window)
+		return Decision{Allowed: true, Remaining: limit - count - n}, nil
+	}
+	m.setLimit(key, log, window)
+
+	d := Decision{Remaining: limit - count}
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	// the request fits once enough of the oldest entries have left the window
+	if i := count + n - limit - 1; i < count {
+		d.RetryAfter = log[i].Add(window).Sub(now)
+	} else {
+		d.RetryAfter = window
+	}
+	return d, nil
+}
+
+// refillTime is how long an untouched bucket takes to fill up again, after
+// which its state can be dropped.
+func refillTime(rate float64, burst int64) time.Duration {
+	return time.Duration(float64(burst)/rate*float64(time.Second)) + time.Second
+}
+
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or burst
+local at = tonumber(state[2]) or now
+if now > at then
+	tokens = math.min(burst, tokens + (now - at) * rate / 1000)
+	at = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) * 1000 / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'at', at)
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return {allowed, math.floor(tokens), retry}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], now, ARGV[5] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, limit - count - n, 0}
+end
+local retry = window
+local i = count + n - limit - 1
+if i < count then
+	local oldest = redis.call('ZRANGE', KEYS[1], i, i, 'WITHSCORES')
+	retry = tonumber(oldest[2]) + window - now
+end
+return {0, math.max(limit - count, 0), retry}
+`)
+
+func (r *Redis) takeTokens(key string, rate float64, burst, n int64, now time.Time) (Decision, error) {
+	return runDecision(tokenBucketScript, r.client, key,
+		rate, burst, n, unixMilli(now), milliseconds(refillTime(rate, burst)))
+}
+
+func (r *Redis) logEvents(key string, limit int64, window time.Duration, n int64, now time.Time) (Decision, error) {
+	id, err := randomID()
+	if err != nil {
+		return Decision{}, err
+	}
+	return runDecision(slidingWindowScript, r.client, key,
+		limit, milliseconds(window), n, unixMilli(now), id)
+}
+
+func runDecision(script *redis.Script, client rediser, key string, args ...interface{}) (Decision, error) {
+	v, err := script.Run(client, []string{key}, args...).Result()
+	if err != nil {
+		return Decision{}, err
+	}
+	reply, ok := v.([]interface{})
+	if !ok || len(reply) != 3 {
+		return Decision{}, errUnexpectedReply
+	}
+	var nums [3]int64
+	for i, x := range reply {
+		if nums[i], ok = x.(int64); !ok {
+			return Decision{}, errUnexpectedReply
+		}
+	}
+	return Decision{
+		Allowed:    nums[0] == 1,
+		Remaining:  nums[1],
+		RetryAfter: time.Duration(nums[2]) * time.Millisecond,
+	}, nil
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package kv_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+// limiters returns each kind of limiter allowing 3 requests at once.
+func limiters(t *testing.T, m *kv.Memory) map[string]kv.Limiter {
+	tb, err := kv.NewTokenBucket(m, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := kv.NewFixedWindow(m, 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw, err := kv.NewSlidingWindow(m, 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]kv.Limiter{"TokenBucket": tb, "FixedWindow": fw, "SlidingWindow": sw}
+}
+
+func TestLimiterConfig(t *testing.T) {
+	m := kv.NewMemory()
+	for _, c := range []struct {
+		rate  float64
+		burst int64
+	}{{0, 1}, {-1, 1}, {math.NaN(), 1}, {math.Inf(1), 1}, {1, 0}} {
+		if _, err := kv.NewTokenBucket(m, c.rate, c.burst); err == nil {
+			t.Errorf("NewTokenBucket(%v, %d): want error", c.rate, c.burst)
+		}
+	}
+	for _, c := range []struct {
+		limit  int64
+		window time.Duration
+	}{{0, time.Second}, {-1, time.Second}, {1, 0}, {1, -time.Second}} {
+		if _, err := kv.NewFixedWindow(m, c.limit, c.window); err == nil {
+			t.Errorf("NewFixedWindow(%d, %v): want error", c.limit, c.window)
+		}
+		if _, err := kv.NewSlidingWindow(m, c.limit, c.window); err == nil {
+			t.Errorf("NewSlidingWindow(%d, %v): want error", c.limit, c.window)
+		}
+	}
+}
+
+func TestLimiterCount(t *testing.T) {
+	for name, l := range limiters(t, kv.NewMemory()) {
+		for _, n := range []int64{0, -100} {
+			if _, err := l.AllowN("count", n); err != kv.ErrRateCount {
+				t.Errorf("%s: AllowN(%d): got %v, want ErrRateCount", name, n, err)
+			}
+		}
+		d, err := l.AllowN("count", 3)
+		if err != nil || !d.Allowed || d.Remaining != 0 {
+			t.Errorf("%s: AllowN(3): got %+v, %v", name, d, err)
+		}
+		if d, err := l.Allow("count"); err != nil || d.Allowed {
+			t.Errorf("%s: Allow over the limit: got %+v, %v", name, d, err)
+		}
+	}
+}
+
+func TestLimiterEviction(t *testing.T) {
+	m := kv.NewMemory(kv.MaxEntries(1))
+	for name, l := range limiters(t, m) {
+		if name == "FixedWindow" {
+			// its counters are ordinary entries
+			continue
+		}
+		if d, err := l.AllowN("evict", 3); err != nil || !d.Allowed {
+			t.Fatalf("%s: AllowN(3): got %+v, %v", name, d, err)
+		}
+		m.Set("a", "a", 0)
+		m.Set("b", "b", 0)
+		if d, err := l.Allow("evict"); err != nil || d.Allowed {
+			t.Errorf("%s: Allow after eviction: got %+v, %v", name, d, err)
+		}
+	}
+}
@@ -1,87 +1,132 @@
 package kv
 
 import (
+	"errors"
+	"sync"
 	"time"
 
-	cache "gopkg.in/go-redis/cache.v5"
 	redis "gopkg.in/redis.v5"
-	msgpack "gopkg.in/vmihailenco/msgpack.v2"
 )
 
+var (
+	errNoMaster        = errors.New("cache: no redis master available")
+	errUnknownClient   = errors.New("cache: unknown redis client")
+	errUnexpectedReply = errors.New("cache: unexpected redis reply")
+)
+
+// rediser is satisfied by both *redis.Client and *redis.ClusterClient.
+type rediser interface {
+	redis.Cmdable
+	Publish(channel, message string) *redis.IntCmd
+	Close() error
+}
+
 type Redis struct {
-	codec *cache.Codec
+	client rediser
+	codec  Codec
+	// db is the database selected on connect, which keyspace
+	// notifications are scoped to
+	db int
 }
 
-func NewRedisCluster(addrs []string) *Redis {
-	codec := &cache.Codec{
-		Redis: redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:        addrs,
-			PoolSize:     512,
-			PoolTimeout:  10 * time.Second,
-			IdleTimeout:  10 * time.Second,
-			DialTimeout:  10 * time.Second,
-			ReadTimeout:  3 * time.Second,
-			WriteTimeout: 3 * time.Second,
-		}),
-		Marshal: func(v interface{}) ([]byte, error) {
-			return msgpack.Marshal(v)
-		},
-		Unmarshal: func(b []byte, v interface{}) error {
-			return msgpack.Unmarshal(b, v)
-		},
+type redisOptions struct {
+	codec Codec
+}
+
+type RedisOption func(opts *redisOptions)
+
+// UseCodec sets how values are encoded in Redis. The default is MsgpackCodec.
+func UseCodec(v Codec) RedisOption {
+	return func(opts *redisOptions) {
+		opts.codec = v
 	}
+}
 
-	return &Redis{codec: codec}
+func NewRedisCluster(addrs []string, opts ...RedisOption) *Redis {
+	redisOpt := RedisOptions{Addrs: addrs, Cluster: true}
+	redisOpt.init()
+	return newRedis(opts, redisOpt.clusterClient())
 }
 
-func NewRedis(addr string) *Redis {
-	codec := &cache.Codec{
-		Redis: redis.NewClient(&redis.Options{
-			DB:           0,
-			Addr:         addr,
-			PoolSize:     512,
-			PoolTimeout:  10 * time.Second,
-			IdleTimeout:  10 * time.Second,
-			DialTimeout:  10 * time.Second,
-			ReadTimeout:  3 * time.Second,
-			WriteTimeout: 3 * time.Second,
-		}),
-		Marshal: func(v interface{}) ([]byte, error) {
-			return msgpack.Marshal(v)
-		},
-		Unmarshal: func(b []byte, v interface{}) error {
-			return msgpack.Unmarshal(b, v)
-		},
-	}
+func NewRedis(addr string, opts ...RedisOption) *Redis {
+	redisOpt := RedisOptions{Addrs: []string{addr}}
+	redisOpt.init()
+	return newRedis(opts, redisOpt.client())
+}
 
-	return &Redis{codec: codec}
+func newRedis(opts []RedisOption, client rediser) *Redis {
+	redisOps := redisOptions{
+		codec: MsgpackCodec,
+	}
+	for _, opt := range opts {
+		opt(&redisOps)
+	}
+	return &Redis{client: client, codec: redisOps.codec}
 }
 
 func (r *Redis) Set(key string, o interface{}, ttl time.Duration) error {
-	return r.codec.Set(&cache.Item{
-		Key:        key,
-		Object:     o,
-		Expiration: ttl,
-	})
+	b, err := r.codec.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(key, b, ttl).Err()
 }
 
 func (r *Redis) Get(key string, o interface{}) error {
-	if err := r.codec.Get(key, o); err != nil {
-		if err == cache.ErrCacheMiss {
+	b, err := r.client.Get(key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
 			return ErrKeyMiss
 		}
-		return err
+		return wrongType(err)
 	}
 
+	if err := r.codec.Unmarshal(b, o); err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
 	return nil
 }
 
 func (r *Redis) Del(key string) error {
-	if err := r.codec.Delete(key); err != nil {
-		if err == cache.ErrCacheMiss {
+	return r.client.Del(key).Err()
+}
+
+func (r *Redis) ping() error {
+	return r.client.Ping().Err()
+}
+
+func (r *Redis) publish(channel, message string) error {
+	return r.client.Publish(channel, message).Err()
+}
+
+func (r *Redis) subscribe(channels ...string) (*redis.PubSub, error) {
+	switch c := r.client.(type) {
+	case *redis.Client:
+		return c.Subscribe(channels...)
+	case *redis.ClusterClient:
+		// published messages reach every node, so one master is enough
+		var mu sync.Mutex
+		var pubsub *redis.PubSub
+		err := c.ForEachMaster(func(master *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if pubsub != nil {
+				return nil
+			}
+			ps, err := master.Subscribe(channels...)
+			if err != nil {
+				return err
+			}
+			pubsub = ps
 			return nil
+		})
+		if pubsub != nil {
+			return pubsub, nil
 		}
-		return err
+		if err == nil {
+			err = errNoMaster
+		}
+		return nil, err
 	}
-	return nil
+	return nil, errUnknownClient
 }
@@ -0,0 +1,226 @@
+package kv
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// RedisOptions configures the connection made by NewRedisWithOptions. Zero
+// durations and sizes fall back to the defaults of NewRedis.
+type RedisOptions struct {
+	// Addrs holds the address of a standalone server, or the seed nodes when
+	// Cluster is set.
+	Addrs   []string
+	Cluster bool
+
+	// MasterName and SentinelAddrs select Sentinel-based failover instead of
+	// a fixed address.
+	MasterName    string
+	SentinelAddrs []string
+
+	// Username authenticates as a Redis 6 ACL user. It and TLSConfig are
+	// only supported for standalone servers.
+	Username  string
+	Password  string
+	DB        int
+	TLSConfig *tls.Config
+
+	PoolSize     int
+	PoolTimeout  time.Duration
+	IdleTimeout  time.Duration
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// ReadFromReplica sends read-only commands to replicas in cluster mode.
+	ReadFromReplica bool
+}
+
+func NewRedisWithOptions(redisOpt RedisOptions, opts ...RedisOption) (*Redis, error) {
+	if err := redisOpt.validate(); err != nil {
+		return nil, err
+	}
+	redisOpt.init()
+
+	var r *Redis
+	switch {
+	case redisOpt.MasterName != "":
+		r = newRedis(opts, redisOpt.failoverClient())
+	case redisOpt.Cluster:
+		r = newRedis(opts, redisOpt.clusterClient())
+	default:
+		r = newRedis(opts, redisOpt.client())
+	}
+	r.db = redisOpt.DB
+	return r, nil
+}
+
+func (o *RedisOptions) validate() error {
+	unsupported := func(option, mode string) error {
+		return fmt.Errorf("cache: redis option %s is not supported in %s mode", option, mode)
+	}
+
+	switch {
+	case o.MasterName != "":
+		if len(o.SentinelAddrs) == 0 {
+			return ErrNoAddr
+		}
+		if o.Cluster {
+			return unsupported("Cluster", "sentinel")
+		}
+		if o.Username != "" {
+			return unsupported("Username", "sentinel")
+		}
+		if o.TLSConfig != nil {
+			return unsupported("TLSConfig", "sentinel")
+		}
+		if o.ReadFromReplica {
+			return unsupported("ReadFromReplica", "sentinel")
+		}
+	case o.Cluster:
+		if len(o.Addrs) == 0 {
+			return ErrNoAddr
+		}
+		if o.Username != "" {
+			return unsupported("Username", "cluster")
+		}
+		if o.TLSConfig != nil {
+			return unsupported("TLSConfig", "cluster")
+		}
+		if o.DB != 0 {
+			return unsupported("DB", "cluster")
+		}
+	default:
+		if len(o.Addrs) != 1 {
+			return ErrNoAddr
+		}
+		if o.ReadFromReplica {
+			return unsupported("ReadFromReplica", "standalone")
+		}
+	}
+	return nil
+}
+
+func (o *RedisOptions) init() {
+	if o.PoolSize == 0 {
+		o.PoolSize = 512
+	}
+	if o.PoolTimeout == 0 {
+		o.PoolTimeout = 10 * time.Second
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = 10 * time.Second
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 10 * time.Second
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = 3 * time.Second
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = 3 * time.Second
+	}
+}
+
+func (o *RedisOptions) client() *redis.Client {
+	opt := &redis.Options{
+		Addr:         o.Addrs[0],
+		Password:     o.Password,
+		DB:           o.DB,
+		TLSConfig:    o.TLSConfig,
+		PoolSize:     o.PoolSize,
+		PoolTimeout:  o.PoolTimeout,
+		IdleTimeout:  o.IdleTimeout,
+		DialTimeout:  o.DialTimeout,
+		ReadTimeout:  o.ReadTimeout,
+		WriteTimeout: o.WriteTimeout,
+	}
+	if o.Username != "" {
+		// the client only knows the single argument AUTH, so authenticate
+		// while dialing instead
+		opt.Password = ""
+		opt.Dialer = o.aclDialer()
+	}
+	return redis.NewClient(opt)
+}
+
+func (o *RedisOptions) clusterClient() *redis.ClusterClient {
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:        o.Addrs,
+		ReadOnly:     o.ReadFromReplica,
+		Password:     o.Password,
+		PoolSize:     o.PoolSize,
+		PoolTimeout:  o.PoolTimeout,
+		IdleTimeout:  o.IdleTimeout,
+		DialTimeout:  o.DialTimeout,
+		ReadTimeout:  o.ReadTimeout,
+		WriteTimeout: o.WriteTimeout,
+	})
+}
+
+func (o *RedisOptions) failoverClient() *redis.Client {
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    o.MasterName,
+		SentinelAddrs: o.SentinelAddrs,
+		Password:      o.Password,
+		DB:            o.DB,
+		PoolSize:      o.PoolSize,
+		PoolTimeout:   o.PoolTimeout,
+		IdleTimeout:   o.IdleTimeout,
+		DialTimeout:   o.DialTimeout,
+		ReadTimeout:   o.ReadTimeout,
+		WriteTimeout:  o.WriteTimeout,
+	})
+}
+
+func (o *RedisOptions) aclDialer() func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", o.Addrs[0], o.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if o.TLSConfig != nil {
+			conn = tls.Client(conn, o.TLSConfig)
+		}
+		if err := o.auth(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+func (o *RedisOptions) auth(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(o.DialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	cmd := fmt.Sprintf("*3\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(o.Username), o.Username, len(o.Password), o.Password)
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		return err
+	}
+
+	// read byte by byte so nothing after the reply line is consumed
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	reply := strings.TrimSuffix(string(line), "\r")
+	if reply != "+OK" {
+		return fmt.Errorf("cache: redis AUTH failed: %s", strings.TrimPrefix(reply, "-"))
+	}
+	return nil
+}
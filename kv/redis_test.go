@@ -0,0 +1,24 @@
+package kv_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xtimeline/gox/kv"
+	"github.com/xtimeline/gox/kv/kvtest"
+)
+
+// newRedis connects to the server at KV_REDIS_ADDR, e.g. localhost:6379,
+// and skips the test if it is not set.
+func newRedis(t *testing.T, opts ...kv.RedisOption) *kv.Redis {
+	addr := os.Getenv("KV_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("KV_REDIS_ADDR not set")
+	}
+	return kv.NewRedis(addr, opts...)
+}
+
+func TestRedis(t *testing.T) {
+	r := newRedis(t)
+	kvtest.Run(t, func() kv.Store { return r })
+}
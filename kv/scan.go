@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Scanner is implemented by stores that can enumerate their keys. match is
+// a Redis style glob pattern: * and ? wildcards, [abc] and [^a-z] classes
+// and \ escapes.
+type Scanner interface {
+	Scan(match string) *Iterator
+}
+
+var (
+	_ Scanner = (*Memory)(nil)
+	_ Scanner = (*Redis)(nil)
+)
+
+// ScanPrefix iterates over the keys of s starting with prefix.
+func ScanPrefix(s Scanner, prefix string) *Iterator {
+	return s.Scan(escapeGlob(prefix) + "*")
+}
+
+// Iterator walks keys page by page:
+//
+//	it := s.Scan("user:*")
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Keys changed during the iteration may be missed or reported twice.
+type Iterator struct {
+	fetch func() (keys []string, done bool, err error)
+	keys  []string
+	key   string
+	done  bool
+	err   error
+}
+
+func (it *Iterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.keys, it.done, it.err = it.fetch()
+	}
+	it.key = it.keys[0]
+	it.keys = it.keys[1:]
+	return true
+}
+
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Scan iterates over a sorted snapshot of the live keys taken when the
+// iteration starts.
+func (m *Memory) Scan(match string) *Iterator {
+	return &Iterator{fetch: func() ([]string, bool, error) {
+		m.mu.Lock()
+		defer m.unlock()
+		now := time.Now().UnixNano()
+		var keys []string
+		for key, e := range m.items {
+			if !e.expired(now) && globMatch(match, key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys, true, nil
+	}}
+}
+
+const scanCount = 100
+
+// Scan uses SCAN, going through every master of a cluster in turn.
+func (r *Redis) Scan(match string) *Iterator {
+	var nodes []redis.Cmdable
+	var node int
+	var cursor uint64
+	return &Iterator{fetch: func() ([]string, bool, error) {
+		if nodes == nil {
+			var err error
+			if nodes, err = r.masters(); err != nil {
+				return nil, false, err
+			}
+			if len(nodes) == 0 {
+				return nil, true, nil
+			}
+		}
+		keys, next, err := nodes[node].Scan(cursor, match, scanCount).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		cursor = next
+		if cursor == 0 {
+			node++
+		}
+		return keys, node == len(nodes), nil
+	}}
+}
+
+// masters returns a client per node holding a share of the keys.
+func (r *Redis) masters() ([]redis.Cmdable, error) {
+	c, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{r.client}, nil
+	}
+	var mu sync.Mutex
+	var nodes []redis.Cmdable
+	err := c.ForEachMaster(func(master *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, master)
+		return nil
+	})
+	return nodes, err
+}
+
+func escapeGlob(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// globMatch reports whether s matches pattern with the rules of Redis KEYS.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class at the start of pattern, just
+// after its opening bracket, and returns the pattern following the class.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// skip the closing bracket
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
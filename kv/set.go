@@ -0,0 +1,197 @@
+package kv
+
+import "sort"
+
+// Set is an unordered collection of distinct values under one key. Members
+// are compared by their encoded form, so they should encode
+// deterministically; maps, for example, do not. Add and Remove return how
+// many members were actually added or removed.
+type Set interface {
+	Add(members ...interface{}) (int64, error)
+	Remove(members ...interface{}) (int64, error)
+	Contains(member interface{}) (bool, error)
+	Members(objs interface{}) error
+	Len() (int64, error)
+}
+
+type redisSet struct {
+	r   *Redis
+	key string
+}
+
+func (r *Redis) SetAt(key string) Set {
+	return &redisSet{r: r, key: key}
+}
+
+func (s *redisSet) Add(members ...interface{}) (int64, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args, err := encodeMembers(s.r.codec, members)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.r.client.SAdd(s.key, args...).Result()
+	return n, wrongType(err)
+}
+
+func (s *redisSet) Remove(members ...interface{}) (int64, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args, err := encodeMembers(s.r.codec, members)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.r.client.SRem(s.key, args...).Result()
+	return n, wrongType(err)
+}
+
+func (s *redisSet) Contains(member interface{}) (bool, error) {
+	b, err := marshalMember(s.r.codec, member)
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.r.client.SIsMember(s.key, b).Result()
+	return ok, wrongType(err)
+}
+
+func (s *redisSet) Members(objs interface{}) error {
+	raws, err := s.r.client.SMembers(s.key).Result()
+	if err != nil {
+		return wrongType(err)
+	}
+	return decodeAll(s.r.codec, s.key, raws, objs)
+}
+
+func (s *redisSet) Len() (int64, error) {
+	n, err := s.r.client.SCard(s.key).Result()
+	return n, wrongType(err)
+}
+
+type memSet struct {
+	members map[string]struct{}
+}
+
+type memorySet struct {
+	m   *memory
+	key string
+}
+
+func (m *Memory) SetAt(key string) Set {
+	return &memorySet{m: m.memory, key: key}
+}
+
+func (s *memorySet) Add(members ...interface{}) (int64, error) {
+	raws, err := s.encode(members)
+	if err != nil || len(raws) == 0 {
+		return 0, err
+	}
+	s.m.mu.Lock()
+	defer s.m.unlock()
+	e, v, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, raw := range raws {
+		if _, found := v.members[raw]; !found {
+			v.members[raw] = struct{}{}
+			n++
+		}
+	}
+	s.m.save(s.key, e, v, false)
+	return n, nil
+}
+
+func (s *memorySet) Remove(members ...interface{}) (int64, error) {
+	raws, err := s.encode(members)
+	if err != nil {
+		return 0, err
+	}
+	s.m.mu.Lock()
+	defer s.m.unlock()
+	e, v, err := s.load()
+	if err != nil || e == nil {
+		return 0, err
+	}
+	var n int64
+	for _, raw := range raws {
+		if _, found := v.members[raw]; found {
+			delete(v.members, raw)
+			n++
+		}
+	}
+	if n > 0 {
+		s.m.save(s.key, e, v, len(v.members) == 0)
+	}
+	return n, nil
+}
+
+func (s *memorySet) Contains(member interface{}) (bool, error) {
+	b, err := marshalMember(s.m.opts.codec, member)
+	if err != nil {
+		return false, err
+	}
+	s.m.mu.Lock()
+	defer s.m.unlock()
+	_, v, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	_, found := v.members[string(b)]
+	return found, nil
+}
+
+// Members returns the members ordered by their encoded form.
+func (s *memorySet) Members(objs interface{}) error {
+	s.m.mu.Lock()
+	_, v, err := s.load()
+	var raws []string
+	if err == nil {
+		raws = make([]string, 0, len(v.members))
+		for raw := range v.members {
+			raws = append(raws, raw)
+		}
+	}
+	s.m.unlock()
+	if err != nil {
+		return err
+	}
+	sort.Strings(raws)
+	return decodeAll(s.m.opts.codec, s.key, raws, objs)
+}
+
+func (s *memorySet) Len() (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.unlock()
+	_, v, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(v.members)), nil
+}
+
+func (s *memorySet) encode(members []interface{}) ([]string, error) {
+	raws := make([]string, len(members))
+	for i, member := range members {
+		b, err := marshalMember(s.m.opts.codec, member)
+		if err != nil {
+			return nil, err
+		}
+		raws[i] = string(b)
+	}
+	return raws, nil
+}
+
+// load returns the set at key, or a new empty one with a nil entry.
+func (s *memorySet) load() (*entry, *memSet, error) {
+	e, err := s.m.structure(s.key, (*memSet)(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		return nil, &memSet{members: make(map[string]struct{})}, nil
+	}
+	return e, e.value.(*memSet), nil
+}
@@ -0,0 +1,429 @@
+package kv
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xtimeline/gox/log"
+)
+
+type shardOptions struct {
+	virtualNodes   int
+	healthInterval time.Duration
+}
+
+type ShardOption func(opts *shardOptions)
+
+// VirtualNodes sets how many points each node gets on the hash ring. More
+// points spread keys more evenly at the cost of a larger ring. It must be
+// positive; the default is 160.
+func VirtualNodes(v int) ShardOption {
+	return func(opts *shardOptions) {
+		opts.virtualNodes = v
+	}
+}
+
+// HealthCheckInterval sets how often nodes are pinged. A value <= 0
+// disables health checks. The default is 5s.
+func HealthCheckInterval(v time.Duration) ShardOption {
+	return func(opts *shardOptions) {
+		opts.healthInterval = v
+	}
+}
+
+var errVirtualNodes = errors.New("cache: virtual nodes must be positive")
+
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+// ringHash places keys and virtual nodes on the ring. Like ketama it uses
+// MD5, whose output stays uniform for the near-identical virtual node names.
+func ringHash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+type byHash []ringPoint
+
+func (r byHash) Len() int      { return len(r) }
+func (r byHash) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byHash) Less(i, j int) bool {
+	if r[i].hash != r[j].hash {
+		return r[i].hash < r[j].hash
+	}
+	return r[i].node < r[j].node
+}
+
+// Sharded spreads keys over independent Redis nodes with consistent
+// hashing, so adding or removing a node only moves the keys of its share
+// of the ring. Keys sharing a {hash tag} live on the same node.
+//
+// Reads and writes of a node failing its health check go to the next node
+// on the ring until it recovers. Values written there in the meantime are
+// not moved back, and a Del there does not reach the owner, so once the
+// owner recovers it serves the values it held before it went down, even
+// ones overwritten or deleted since. Only use Sharded as a cache that
+// tolerates such stale reads. Counters, conditional writes and locks do not
+// fail over, since a fresh count or missing state on another node would
+// break them; they fail with ErrNoNode while their node is down.
+type Sharded struct {
+	opts   shardOptions
+	mu     sync.RWMutex
+	nodes  map[string]*Redis
+	down   map[string]bool
+	ring   []ringPoint
+	closed chan struct{}
+	once   sync.Once
+}
+
+var (
+	_ Store       = (*Sharded)(nil)
+	_ Batcher     = (*Sharded)(nil)
+	_ Counter     = (*Sharded)(nil)
+	_ Conditional = (*Sharded)(nil)
+	_ Expirer     = (*Sharded)(nil)
+	_ Locker      = (*Sharded)(nil)
+)
+
+// NewSharded builds a ring over nodes keyed by name. Keys are placed by
+// name rather than address, so a node can move to another address without
+// remapping its keys.
+func NewSharded(nodes map[string]*Redis, opts ...ShardOption) (*Sharded, error) {
+	shardOps := shardOptions{
+		virtualNodes:   160,
+		healthInterval: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&shardOps)
+	}
+	if shardOps.virtualNodes <= 0 {
+		return nil, errVirtualNodes
+	}
+
+	s := &Sharded{
+		opts:   shardOps,
+		nodes:  make(map[string]*Redis),
+		down:   make(map[string]bool),
+		closed: make(chan struct{}),
+	}
+	for name, r := range nodes {
+		s.nodes[name] = r
+	}
+	s.rebuild()
+	if shardOps.healthInterval > 0 {
+		go s.healthCheck()
+	}
+	return s, nil
+}
+
+// Add puts a node on the ring, replacing any node of the same name.
+func (s *Sharded) Add(name string, r *Redis) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[name] = r
+	delete(s.down, name)
+	s.rebuild()
+}
+
+// Remove takes a node off the ring. Its client is not closed.
+func (s *Sharded) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, name)
+	delete(s.down, name)
+	s.rebuild()
+}
+
+// Node returns the node key currently maps to, for operations Sharded does
+// not route itself.
+func (s *Sharded) Node(key string) (*Redis, error) {
+	return s.node(key, true)
+}
+
+// node returns the node owning key on the ring. With failover, a node
+// that is down is skipped for the next one; without, it fails with
+// ErrNoNode.
+func (s *Sharded) node(key string, failover bool) (*Redis, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.ring) == 0 {
+		return nil, ErrNoNode
+	}
+	h := ringHash(hashTag(key))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	for n := 0; n < len(s.ring); n++ {
+		p := s.ring[(i+n)%len(s.ring)]
+		if !s.down[p.node] {
+			return s.nodes[p.node], nil
+		}
+		if !failover {
+			break
+		}
+	}
+	return nil, ErrNoNode
+}
+
+// Close stops the health checks. The node clients are left open.
+func (s *Sharded) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
+
+func (s *Sharded) Set(key string, o interface{}, ttl time.Duration) error {
+	r, err := s.Node(key)
+	if err != nil {
+		return err
+	}
+	return r.Set(key, o, ttl)
+}
+
+func (s *Sharded) Get(key string, o interface{}) error {
+	r, err := s.Node(key)
+	if err != nil {
+		return err
+	}
+	return r.Get(key, o)
+}
+
+func (s *Sharded) Del(key string) error {
+	r, err := s.Node(key)
+	if err != nil {
+		return err
+	}
+	return r.Del(key)
+}
+
+func (s *Sharded) MGet(keys []string, objs []interface{}) ([]bool, error) {
+	if len(keys) != len(objs) {
+		return nil, ErrBatchLength
+	}
+	groups, err := s.split(keys)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(keys))
+	for r, group := range groups {
+		picked := make([]interface{}, len(group))
+		for i, index := range group {
+			picked[i] = objs[index]
+		}
+		ok, err := r.MGet(pick(keys, group), picked)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range group {
+			found[index] = ok[i]
+		}
+	}
+	return found, nil
+}
+
+func (s *Sharded) MSet(keys []string, objs []interface{}, ttl time.Duration) error {
+	if len(keys) != len(objs) {
+		return ErrBatchLength
+	}
+	groups, err := s.split(keys)
+	if err != nil {
+		return err
+	}
+	for r, group := range groups {
+		picked := make([]interface{}, len(group))
+		for i, index := range group {
+			picked[i] = objs[index]
+		}
+		if err := r.MSet(pick(keys, group), picked, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sharded) MDel(keys ...string) error {
+	groups, err := s.split(keys)
+	if err != nil {
+		return err
+	}
+	for r, group := range groups {
+		if err := r.MDel(pick(keys, group)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sharded) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	r, err := s.node(key, false)
+	if err != nil {
+		return 0, err
+	}
+	return r.Incr(key, delta, ttl)
+}
+
+func (s *Sharded) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return s.Incr(key, -delta, ttl)
+}
+
+func (s *Sharded) SetNX(key string, o interface{}, ttl time.Duration) (bool, error) {
+	r, err := s.node(key, false)
+	if err != nil {
+		return false, err
+	}
+	return r.SetNX(key, o, ttl)
+}
+
+func (s *Sharded) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	r, err := s.node(key, false)
+	if err != nil {
+		return false, err
+	}
+	return r.CompareAndSwap(key, old, new, ttl)
+}
+
+func (s *Sharded) TTL(key string) (time.Duration, error) {
+	r, err := s.Node(key)
+	if err != nil {
+		return 0, err
+	}
+	return r.TTL(key)
+}
+
+func (s *Sharded) Expire(key string, ttl time.Duration) error {
+	r, err := s.Node(key)
+	if err != nil {
+		return err
+	}
+	return r.Expire(key, ttl)
+}
+
+func (s *Sharded) Persist(key string) error {
+	r, err := s.Node(key)
+	if err != nil {
+		return err
+	}
+	return r.Persist(key)
+}
+
+// AcquireLock routes by the lock key. The lock keys share a hash tag, so a
+// lock and its fencing counter always live on the same node. Locks never
+// fail over: another node knows neither the holder nor the last token.
+func (s *Sharded) AcquireLock(name, owner string, ttl time.Duration) (int64, error) {
+	r, err := s.node(lockKey(name), false)
+	if err != nil {
+		return 0, err
+	}
+	return r.AcquireLock(name, owner, ttl)
+}
+
+func (s *Sharded) RenewLock(name, owner string, ttl time.Duration) (bool, error) {
+	r, err := s.node(lockKey(name), false)
+	if err != nil {
+		return false, err
+	}
+	return r.RenewLock(name, owner, ttl)
+}
+
+func (s *Sharded) ReleaseLock(name, owner string) (bool, error) {
+	r, err := s.node(lockKey(name), false)
+	if err != nil {
+		return false, err
+	}
+	return r.ReleaseLock(name, owner)
+}
+
+// split groups the indexes of keys by the node serving them.
+func (s *Sharded) split(keys []string) (map[*Redis][]int, error) {
+	groups := make(map[*Redis][]int)
+	for i, key := range keys {
+		r, err := s.Node(key)
+		if err != nil {
+			return nil, err
+		}
+		groups[r] = append(groups[r], i)
+	}
+	return groups, nil
+}
+
+// rebuild recomputes the ring from nodes; mu must be held.
+func (s *Sharded) rebuild() {
+	ring := make([]ringPoint, 0, len(s.nodes)*s.opts.virtualNodes)
+	for name := range s.nodes {
+		for i := 0; i < s.opts.virtualNodes; i++ {
+			h := ringHash(name + "#" + strconv.Itoa(i))
+			ring = append(ring, ringPoint{hash: h, node: name})
+		}
+	}
+	sort.Sort(byHash(ring))
+	s.ring = ring
+}
+
+func (s *Sharded) healthCheck() {
+	ticker := time.NewTicker(s.opts.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		s.checkNodes()
+	}
+}
+
+func (s *Sharded) checkNodes() {
+	s.mu.RLock()
+	nodes := make(map[string]*Redis, len(s.nodes))
+	for name, r := range s.nodes {
+		nodes[name] = r
+	}
+	s.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	for name, r := range nodes {
+		wg.Add(1)
+		go func(name string, r *Redis) {
+			defer wg.Done()
+			if err := r.ping(); err != nil {
+				mu.Lock()
+				failed[name] = err
+				mu.Unlock()
+			}
+		}(name, r)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, r := range nodes {
+		// skip nodes removed or replaced while pinging
+		if s.nodes[name] != r {
+			continue
+		}
+		err, isDown := failed[name]
+		switch {
+		case isDown && !s.down[name]:
+			s.down[name] = true
+			l.PithyWarn("kv shard node down", map[string]interface{}{
+				"node":  name,
+				"error": err.Error(),
+			})
+		case !isDown && s.down[name]:
+			delete(s.down, name)
+			l.PithyInfo("kv shard node up", map[string]interface{}{
+				"node": name,
+			})
+		}
+	}
+}
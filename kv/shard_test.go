@@ -0,0 +1,23 @@
+package kv_test
+
+import (
+	"testing"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+func TestShardedVirtualNodes(t *testing.T) {
+	for _, v := range []int{0, -1} {
+		if _, err := kv.NewSharded(nil, kv.VirtualNodes(v), kv.HealthCheckInterval(0)); err == nil {
+			t.Errorf("VirtualNodes(%d): want error", v)
+		}
+	}
+	s, err := kv.NewSharded(nil, kv.VirtualNodes(1), kv.HealthCheckInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Node("k"); err != kv.ErrNoNode {
+		t.Errorf("Node on an empty ring: got %v, want ErrNoNode", err)
+	}
+}
@@ -0,0 +1,37 @@
+package kv
+
+import "strings"
+
+const slotCount = 16384
+
+// hashSlot maps key to its Redis Cluster slot, honoring {hash tags}.
+func hashSlot(key string) int {
+	return int(crc16(hashTag(key)) % slotCount)
+}
+
+// hashTag returns the part of key that decides where it is stored: the
+// first non-empty {hash tag}, or the whole key.
+func hashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+// crc16 is the CCITT (XMODEM) variant used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
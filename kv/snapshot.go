@@ -0,0 +1,310 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/xtimeline/gox/log"
+)
+
+// A snapshot file is laid out as
+//
+//	magic    [8]byte  "GOXKVSNP"
+//	version  uint32
+//	count    uint64   number of records
+//	length   uint64   length of body
+//	body     [length]byte, gob encoded records
+//	checksum uint32   CRC-32 (IEEE) of body
+//
+// with all integers big endian. Records are written from least to most
+// recently used, so restoring them in order rebuilds the recency of the
+// entries, and are followed by the state of rate limiters.
+var snapshotMagic = [8]byte{'G', 'O', 'X', 'K', 'V', 'S', 'N', 'P'}
+
+const snapshotVersion = 1
+
+type snapshotHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Count   uint64
+	Length  uint64
+}
+
+type snapshotRecord struct {
+	Key   string
+	Value interface{}
+	// Expires is the expiration as unix nanoseconds, 0 if the entry never
+	// expires. Keeping the absolute time means entries do not outlive their
+	// ttl by the time the process was down.
+	Expires int64
+}
+
+// Snapshot writes the live entries of m to w. Values are encoded with
+// encoding/gob, so the concrete types of values stored behind interfaces
+// must be registered with gob.Register. Entries gob cannot encode are left
+// out of the snapshot and logged.
+func (m *Memory) Snapshot(w io.Writer) error {
+	records := m.records()
+
+	var body bytes.Buffer
+	enc := gob.NewEncoder(&body)
+	count := 0
+	var skipped []string
+	var skipErr error
+	for i := range records {
+		// a failed Encode may leave enc out of step with the stream, so
+		// records are tried on a scratch encoder first
+		if err := gob.NewEncoder(ioutil.Discard).Encode(&records[i]); err != nil {
+			if skipErr == nil {
+				skipErr = err
+			}
+			skipped = append(skipped, records[i].Key)
+			continue
+		}
+		if err := enc.Encode(&records[i]); err != nil {
+			return err
+		}
+		count++
+	}
+	if len(skipped) > 0 {
+		l.PithyWarn("kv snapshot skipped entries", map[string]interface{}{
+			"count": len(skipped),
+			"key":   skipped[0],
+			"error": skipErr.Error(),
+		})
+	}
+
+	h := snapshotHeader{
+		Magic:   snapshotMagic,
+		Version: snapshotVersion,
+		Count:   uint64(count),
+		Length:  uint64(body.Len()),
+	}
+	if err := binary.Write(w, binary.BigEndian, &h); err != nil {
+		return err
+	}
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(body.Bytes()))
+}
+
+// Restore loads a snapshot written by Snapshot into m. Entries that expired
+// in the meantime are skipped and restored entries replace existing entries
+// with the same key. The snapshot is verified as a whole before any entry
+// is added, so a corrupt file leaves m unchanged.
+func (m *Memory) Restore(r io.Reader) error {
+	var h snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return ErrSnapshotCorrupt
+	}
+	if h.Magic != snapshotMagic {
+		return ErrSnapshotCorrupt
+	}
+	if h.Version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	var body bytes.Buffer
+	if n, err := io.CopyN(&body, r, int64(h.Length)); err != nil || n != int64(h.Length) {
+		return ErrSnapshotCorrupt
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return ErrSnapshotCorrupt
+	}
+	if sum != crc32.ChecksumIEEE(body.Bytes()) {
+		return ErrSnapshotCorrupt
+	}
+
+	var records []snapshotRecord
+	dec := gob.NewDecoder(&body)
+	for i := uint64(0); i < h.Count; i++ {
+		var rec snapshotRecord
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+	now := time.Now().UnixNano()
+	for _, rec := range records {
+		var ttl time.Duration
+		if rec.Expires > 0 {
+			if rec.Expires <= now {
+				continue
+			}
+			ttl = time.Duration(rec.Expires - now)
+		}
+		switch v := restoreValue(rec.Value).(type) {
+		case bucketState, []time.Time:
+			m.setLimit(rec.Key, v, ttl)
+		default:
+			m.set(rec.Key, v, ttl)
+		}
+	}
+	return nil
+}
+
+// SaveFile writes a snapshot of m to path. The snapshot is written to a
+// temporary file first and renamed into place, so a crash never leaves a
+// truncated snapshot behind.
+func (m *Memory) SaveFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := m.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadFile restores the snapshot at path into m. A missing file is
+// reported with an error satisfying os.IsNotExist.
+func (m *Memory) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Restore(f)
+}
+
+// records returns the live entries of m ordered from least to most recently
+// used, followed by the live limiter state.
+func (m *Memory) records() []snapshotRecord {
+	m.mu.Lock()
+	defer m.unlock()
+	now := time.Now().UnixNano()
+	entries := make([]*entry, 0, len(m.items))
+	for _, e := range m.items {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Sort(bySeq(entries))
+
+	records := make([]snapshotRecord, len(entries))
+	for i, e := range entries {
+		records[i] = snapshotRecord{Key: e.key, Value: snapshotValue(e.value), Expires: e.expires}
+	}
+	for key, l := range m.limits {
+		if l.expires > now {
+			records = append(records, snapshotRecord{Key: key, Value: snapshotValue(l.value), Expires: l.expires})
+		}
+	}
+	return records
+}
+
+type bySeq []*entry
+
+func (s bySeq) Len() int           { return len(s) }
+func (s bySeq) Less(i, j int) bool { return s[i].seq < s[j].seq }
+func (s bySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// The values the package itself keeps in Memory have unexported fields and
+// are mutated in place, so snapshots hold copies of them in these forms.
+type (
+	snapBucket struct {
+		Tokens float64
+		At     time.Time
+	}
+	snapWindow    struct{ Times []time.Time }
+	snapHash      struct{ Fields map[string][]byte }
+	snapList      struct{ Items [][]byte }
+	snapSet       struct{ Members []string }
+	snapSortedSet struct{ Scores map[string]float64 }
+)
+
+func init() {
+	gob.Register(tagMeta{})
+	gob.Register(counter(0))
+	gob.Register(snapBucket{})
+	gob.Register(snapWindow{})
+	gob.Register(snapHash{})
+	gob.Register(snapList{})
+	gob.Register(snapSet{})
+	gob.Register(snapSortedSet{})
+}
+
+// snapshotValue returns the form v is written to a snapshot in; mu must be
+// held.
+func snapshotValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bucketState:
+		return snapBucket{Tokens: v.tokens, At: v.at}
+	case []time.Time:
+		return snapWindow{Times: append([]time.Time(nil), v...)}
+	case *memHash:
+		fields := make(map[string][]byte, len(v.fields))
+		for field, b := range v.fields {
+			fields[field] = b
+		}
+		return snapHash{Fields: fields}
+	case *memList:
+		return snapList{Items: append([][]byte(nil), v.items...)}
+	case *memSet:
+		members := make([]string, 0, len(v.members))
+		for member := range v.members {
+			members = append(members, member)
+		}
+		return snapSet{Members: members}
+	case *memSortedSet:
+		scores := make(map[string]float64, len(v.scores))
+		for member, score := range v.scores {
+			scores[member] = score
+		}
+		return snapSortedSet{Scores: scores}
+	}
+	return v
+}
+
+// restoreValue reverses snapshotValue.
+func restoreValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case snapBucket:
+		return bucketState{tokens: v.Tokens, at: v.At}
+	case snapWindow:
+		return v.Times
+	case snapHash:
+		if v.Fields == nil {
+			v.Fields = make(map[string][]byte)
+		}
+		return &memHash{fields: v.Fields}
+	case snapList:
+		return &memList{items: v.Items}
+	case snapSet:
+		members := make(map[string]struct{}, len(v.Members))
+		for _, member := range v.Members {
+			members[member] = struct{}{}
+		}
+		return &memSet{members: members}
+	case snapSortedSet:
+		if v.Scores == nil {
+			v.Scores = make(map[string]float64)
+		}
+		return &memSortedSet{scores: v.Scores}
+	}
+	return v
+}
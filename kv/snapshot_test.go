@@ -0,0 +1,96 @@
+package kv_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+func TestSnapshot(t *testing.T) {
+	m := kv.NewMemory()
+	m.Set("plain", "p", time.Hour)
+	m.HashAt("h").Set("f", "v")
+	m.ListAt("l").PushBack("a", "b")
+	m.SetAt("s").Add("m1", "m2")
+	m.SortedSetAt("z").Add("p", 2.5)
+	m.Incr("counter", 7, 0)
+	kv.NewTagged(m).SetTags("tagged", "tv", time.Minute, "tag")
+	for name, l := range limiters(t, m) {
+		if d, err := l.AllowN("full", 3); err != nil || !d.Allowed {
+			t.Fatalf("%s: AllowN(3): got %+v, %v", name, d, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	n := kv.NewMemory()
+	if err := n.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err := n.Get("plain", &s); err != nil || s != "p" {
+		t.Errorf("plain: got %q, %v", s, err)
+	}
+	if ttl, err := n.TTL("plain"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("plain ttl: got %v, %v", ttl, err)
+	}
+	if err := n.HashAt("h").Get("f", &s); err != nil || s != "v" {
+		t.Errorf("hash: got %q, %v", s, err)
+	}
+	var items []string
+	if err := n.ListAt("l").Range(0, -1, &items); err != nil || len(items) != 2 || items[0] != "a" {
+		t.Errorf("list: got %v, %v", items, err)
+	}
+	if ok, err := n.SetAt("s").Contains("m2"); err != nil || !ok {
+		t.Errorf("set: got %v, %v", ok, err)
+	}
+	if score, err := n.SortedSetAt("z").Score("p"); err != nil || score != 2.5 {
+		t.Errorf("sorted set: got %v, %v", score, err)
+	}
+	if c, err := n.Incr("counter", 1, 0); err != nil || c != 8 {
+		t.Errorf("counter: got %d, %v", c, err)
+	}
+
+	tagged := kv.NewTagged(n)
+	if err := tagged.Get("tagged", &s); err != nil || s != "tv" {
+		t.Errorf("tagged: got %q, %v", s, err)
+	}
+	if err := tagged.Invalidate("tag"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagged.Get("tagged", &s); err != kv.ErrKeyMiss {
+		t.Errorf("tagged after Invalidate: got %v, want ErrKeyMiss", err)
+	}
+
+	for name, l := range limiters(t, n) {
+		if d, err := l.Allow("full"); err != nil || d.Allowed {
+			t.Errorf("%s: Allow after Restore: got %+v, %v", name, d, err)
+		}
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	m := kv.NewMemory()
+	m.Set("a", "a", 0)
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	b[len(b)-5] ^= 0xff
+
+	n := kv.NewMemory()
+	n.Set("a", "old", 0)
+	if err := n.Restore(bytes.NewReader(b)); err != kv.ErrSnapshotCorrupt {
+		t.Fatalf("Restore: got %v, want ErrSnapshotCorrupt", err)
+	}
+	var s string
+	if err := n.Get("a", &s); err != nil || s != "old" {
+		t.Errorf("a after failed Restore: got %q, %v", s, err)
+	}
+}
@@ -0,0 +1,116 @@
+package kv
+
+import (
+	"reflect"
+	"time"
+)
+
+type staleOptions struct {
+	staleOnError bool
+}
+
+type StaleOption func(opts *staleOptions)
+
+// ServeStaleOnError revalidates stale entries synchronously and only serves
+// the stale value if load fails, instead of serving it right away while
+// refreshing in the background.
+func ServeStaleOnError() StaleOption {
+	return func(opts *staleOptions) {
+		opts.staleOnError = true
+	}
+}
+
+// Stale is a read-through cache whose entries have a soft and a hard ttl.
+// Past the soft ttl an entry is stale: it is still served, but reloaded.
+// Past the hard ttl it is gone and the next Get waits for load.
+type Stale struct {
+	store Store
+	soft  time.Duration
+	hard  time.Duration
+	opts  staleOptions
+	loads group
+}
+
+func NewStale(s Store, soft, hard time.Duration, opts ...StaleOption) *Stale {
+	staleOps := staleOptions{}
+	for _, opt := range opts {
+		opt(&staleOps)
+	}
+	return &Stale{
+		store: s,
+		soft:  soft,
+		hard:  hard,
+		opts:  staleOps,
+	}
+}
+
+// Get fills o with the cached value of key, calling load as described on
+// Stale. Concurrent loads of the same key are coalesced.
+func (c *Stale) Get(key string, o interface{}, load LoadFunc) error {
+	dst := reflect.ValueOf(o)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrInvalidTarget
+	}
+	envType := staleEnvelope(dst.Type().Elem())
+
+	env := reflect.New(envType)
+	err := c.store.Get(key, env.Interface())
+	if err != nil && err != ErrKeyMiss && !IsErrDecode(err) {
+		return err
+	}
+	if err != nil {
+		return c.load(key, o, envType, load)
+	}
+
+	freshUntil := env.Elem().Field(0).Int()
+	if time.Now().UnixNano() < freshUntil {
+		dst.Elem().Set(env.Elem().Field(1))
+		return nil
+	}
+	if !c.opts.staleOnError {
+		dst.Elem().Set(env.Elem().Field(1))
+		c.loads.start(key, func() (interface{}, error) {
+			return c.refresh(key, envType, load)
+		})
+		return nil
+	}
+	if err := c.load(key, o, envType, load); err != nil {
+		dst.Elem().Set(env.Elem().Field(1))
+	}
+	return nil
+}
+
+func (c *Stale) load(key string, o interface{}, envType reflect.Type, load LoadFunc) error {
+	v, err := c.loads.do(key, func() (interface{}, error) {
+		return c.refresh(key, envType, load)
+	})
+	if err != nil {
+		return err
+	}
+	return assign(o, v)
+}
+
+// refresh calls load and stores its result. A failure to store is not
+// reported, the loaded value is still good to serve.
+func (c *Stale) refresh(key string, envType reflect.Type, load LoadFunc) (interface{}, error) {
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	env := reflect.New(envType).Elem()
+	env.Field(0).SetInt(time.Now().Add(c.soft).UnixNano())
+	if err := assign(env.Field(1).Addr().Interface(), v); err != nil {
+		return nil, err
+	}
+	c.store.Set(key, env.Interface(), c.hard)
+	return v, nil
+}
+
+// staleEnvelope returns the type stored for values of type t, which carries
+// the end of the soft ttl along with the value.
+func staleEnvelope(t reflect.Type) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "FreshUntil", Type: reflect.TypeOf(int64(0))},
+		{Name: "Value", Type: t},
+	})
+}
@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xtimeline/gox/log"
+)
+
+type OpStats struct {
+	Count  int64
+	Errors int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+func (s OpStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Errors    int64
+	Evictions int64
+	Ops       map[string]OpStats
+}
+
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Fields flattens s into log fields.
+func (s Stats) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"hits":      s.Hits,
+		"misses":    s.Misses,
+		"errors":    s.Errors,
+		"evictions": s.Evictions,
+		"hit_ratio": s.HitRatio(),
+	}
+	for name, op := range s.Ops {
+		fields[name+"_count"] = op.Count
+		fields[name+"_errors"] = op.Errors
+		fields[name+"_mean_us"] = int64(op.Mean() / time.Microsecond)
+		fields[name+"_max_us"] = int64(op.Max / time.Microsecond)
+	}
+	return fields
+}
+
+// Instrumented wraps a store and records hits, misses, errors and the
+// latency of every operation. Evictions are counted when the wrapped store
+// is a Memory.
+type Instrumented struct {
+	store Store
+	name  string
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+var (
+	_ Store   = (*Instrumented)(nil)
+	_ Batcher = (*Instrumented)(nil)
+)
+
+func Instrument(s Store, name string) *Instrumented {
+	i := &Instrumented{
+		store: s,
+		name:  name,
+		stats: Stats{Ops: make(map[string]OpStats)},
+	}
+	if m, ok := s.(*Memory); ok {
+		m.observeEvictions(func(string, interface{}, EvictReason) {
+			i.mu.Lock()
+			i.stats.Evictions++
+			i.mu.Unlock()
+		})
+	}
+	return i
+}
+
+func (i *Instrumented) Set(key string, o interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := i.store.Set(key, o, ttl)
+	i.record("set", start, err, 0, 0)
+	return err
+}
+
+func (i *Instrumented) Get(key string, o interface{}) error {
+	start := time.Now()
+	err := i.store.Get(key, o)
+	switch err {
+	case nil:
+		i.record("get", start, nil, 1, 0)
+	case ErrKeyMiss:
+		i.record("get", start, nil, 0, 1)
+	default:
+		i.record("get", start, err, 0, 0)
+	}
+	return err
+}
+
+func (i *Instrumented) Del(key string) error {
+	start := time.Now()
+	err := i.store.Del(key)
+	i.record("del", start, err, 0, 0)
+	return err
+}
+
+// MGet, MSet and MDel fall back to one call per key when the wrapped store
+// is not a Batcher.
+func (i *Instrumented) MGet(keys []string, objs []interface{}) ([]bool, error) {
+	start := time.Now()
+	found, err := mget(i.store, keys, objs)
+	var hits int64
+	for _, ok := range found {
+		if ok {
+			hits++
+		}
+	}
+	i.record("mget", start, err, hits, int64(len(found))-hits)
+	return found, err
+}
+
+func (i *Instrumented) MSet(keys []string, objs []interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := mset(i.store, keys, objs, ttl)
+	i.record("mset", start, err, 0, 0)
+	return err
+}
+
+func (i *Instrumented) MDel(keys ...string) error {
+	start := time.Now()
+	err := mdel(i.store, keys)
+	i.record("mdel", start, err, 0, 0)
+	return err
+}
+
+// Stats returns a snapshot of the counters.
+func (i *Instrumented) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	snapshot := i.stats
+	snapshot.Ops = make(map[string]OpStats, len(i.stats.Ops))
+	for name, op := range i.stats.Ops {
+		snapshot.Ops[name] = op
+	}
+	return snapshot
+}
+
+// Reset zeroes the counters.
+func (i *Instrumented) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stats = Stats{Ops: make(map[string]OpStats)}
+}
+
+// LogStats writes a snapshot of the counters through the gox logger.
+func (i *Instrumented) LogStats() {
+	fields := i.Stats().Fields()
+	fields["store"] = i.name
+	l.PithyInfo("kv stats", fields)
+}
+
+func (i *Instrumented) record(op string, start time.Time, err error, hits, misses int64) {
+	elapsed := time.Since(start)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s := i.stats.Ops[op]
+	s.Count++
+	s.Total += elapsed
+	if elapsed > s.Max {
+		s.Max = elapsed
+	}
+	if err != nil {
+		s.Errors++
+		i.stats.Errors++
+	}
+	i.stats.Ops[op] = s
+	i.stats.Hits += hits
+	i.stats.Misses += misses
+}
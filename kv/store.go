@@ -0,0 +1,20 @@
+package kv
+
+import "time"
+
+// Store is the behavior shared by every kv backend.
+//
+// Get fills o, which must be a non-nil pointer, and returns ErrKeyMiss when
+// the key is absent or expired. A ttl <= 0 stores the entry without
+// expiration. Del of an absent key is not an error.
+type Store interface {
+	Set(key string, o interface{}, ttl time.Duration) error
+	Get(key string, o interface{}) error
+	Del(key string) error
+}
+
+var (
+	_ Store = (*Memory)(nil)
+	_ Store = (*Redis)(nil)
+	_ Store = (*Tiered)(nil)
+)
@@ -0,0 +1,148 @@
+package kv
+
+import (
+	"reflect"
+	"strings"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// Structures is implemented by stores with Redis style data structures.
+// Elements are encoded with the store's codec, so they are written and read
+// back like regular values. Set and sorted set members are compared by their
+// encoded form, so their operations fail with ErrUnsupported under a codec
+// that does not encode a value the same way every time, such as Encrypt. A structure lives under its key like any other
+// value: Del removes it, Expirer changes its expiration, and it disappears
+// once it is empty. Operating on a key holding another kind of value fails
+// with ErrWrongType.
+//
+// Ranges use Redis indexes: they are zero based, inclusive at both ends,
+// and negative indexes count from the end.
+type Structures interface {
+	HashAt(key string) Hash
+	ListAt(key string) List
+	SetAt(key string) Set
+	SortedSetAt(key string) SortedSet
+}
+
+var (
+	_ Structures = (*Memory)(nil)
+	_ Structures = (*Redis)(nil)
+)
+
+// wrongType translates the reply Redis gives for a key of another type.
+func wrongType(err error) error {
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		return ErrWrongType
+	}
+	return err
+}
+
+// encodeAll encodes objs as command arguments.
+func encodeAll(codec Codec, objs []interface{}) ([]interface{}, error) {
+	args := make([]interface{}, len(objs))
+	for i, o := range objs {
+		b, err := codec.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = b
+	}
+	return args, nil
+}
+
+// encodeMembers encodes set members as command arguments.
+func encodeMembers(codec Codec, members []interface{}) ([]interface{}, error) {
+	if !deterministic(codec) {
+		return nil, ErrUnsupported
+	}
+	return encodeAll(codec, members)
+}
+
+// marshalMember encodes a value compared by its encoded form.
+func marshalMember(codec Codec, member interface{}) ([]byte, error) {
+	if !deterministic(codec) {
+		return nil, ErrUnsupported
+	}
+	return codec.Marshal(member)
+}
+
+// decodeAll decodes raws into a new slice stored in the slice objs points
+// to.
+func decodeAll(codec Codec, key string, raws []string, objs interface{}) error {
+	v := reflect.ValueOf(objs)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return ErrInvalidTarget
+	}
+	slice := reflect.MakeSlice(v.Elem().Type(), len(raws), len(raws))
+	for i, raw := range raws {
+		if err := codec.Unmarshal([]byte(raw), slice.Index(i).Addr().Interface()); err != nil {
+			return &DecodeError{Key: key, Err: err}
+		}
+	}
+	v.Elem().Set(slice)
+	return nil
+}
+
+func decodeOne(codec Codec, key string, raw []byte, err error, o interface{}) error {
+	if err == redis.Nil {
+		return ErrKeyMiss
+	}
+	if err != nil {
+		return wrongType(err)
+	}
+	if err := codec.Unmarshal(raw, o); err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// structure returns the entry of the structure at key, or nil if key is
+// absent. zero tells the kind of structure expected.
+func (m *memory) structure(key string, zero interface{}) (*entry, error) {
+	e, found := m.get(key)
+	if !found {
+		return nil, nil
+	}
+	if reflect.TypeOf(e.value) != reflect.TypeOf(zero) {
+		return nil, ErrWrongType
+	}
+	return e, nil
+}
+
+// save stores the changed structure v at key, whose entry e is nil if key
+// was absent. An empty structure is removed.
+func (m *memory) save(key string, e *entry, v interface{}, empty bool) {
+	switch {
+	case empty:
+		if e != nil {
+			m.remove(e)
+			m.notify(EventDel, key)
+		}
+	case e != nil:
+		m.update(e, v)
+	default:
+		m.set(key, v, 0)
+	}
+}
+
+// span resolves a Redis style index range over n elements to the half
+// open interval [from, to).
+func span(start, stop int64, n int) (from, to int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
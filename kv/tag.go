@@ -0,0 +1,128 @@
+package kv
+
+import "time"
+
+// TagStore is a store that can keep the version counters of tags.
+type TagStore interface {
+	Store
+	Counter
+}
+
+// Tagged lets entries carry tags and drops every entry of a tag at once.
+// Each tag has a version counter; entries remember the versions of their
+// tags when written, and Invalidate bumps the counters so older entries
+// read as misses. Invalidation is O(1) per tag, stale entries are removed
+// lazily when read or by their ttl. Counters are seeded from the clock, so
+// one lost to eviction never comes back at a version older entries saw.
+type Tagged struct {
+	store TagStore
+}
+
+var _ Store = (*Tagged)(nil)
+
+// tagMeta is stored next to each entry.
+type tagMeta struct {
+	Tags     []string
+	Versions []int64
+}
+
+func NewTagged(s TagStore) *Tagged {
+	return &Tagged{store: s}
+}
+
+func (t *Tagged) Set(key string, o interface{}, ttl time.Duration) error {
+	return t.SetTags(key, o, ttl)
+}
+
+// SetTags stores o under key and attaches tags to it.
+func (t *Tagged) SetTags(key string, o interface{}, ttl time.Duration, tags ...string) error {
+	versions, err := t.versions(tags)
+	if err != nil {
+		return err
+	}
+	meta := tagMeta{Tags: tags, Versions: versions}
+	return mset(t.store, []string{key, tagMetaKey(key)}, []interface{}{o, meta}, ttl)
+}
+
+func (t *Tagged) Get(key string, o interface{}) error {
+	// read into a private value so o is untouched if the entry is stale
+	tmp, err := newTarget(o)
+	if err != nil {
+		return err
+	}
+	var meta tagMeta
+	found, err := mget(t.store, []string{key, tagMetaKey(key)}, []interface{}{tmp, &meta})
+	if err != nil {
+		return err
+	}
+	if !found[0] {
+		return ErrKeyMiss
+	}
+	// every entry is written with its meta, so without it the versions
+	// cannot be checked
+	if !found[1] {
+		t.Del(key)
+		return ErrKeyMiss
+	}
+
+	current, err := t.versions(meta.Tags)
+	if err != nil {
+		return err
+	}
+	for i := range current {
+		if i >= len(meta.Versions) || meta.Versions[i] != current[i] {
+			t.Del(key)
+			return ErrKeyMiss
+		}
+	}
+	copyTarget(o, tmp)
+	return nil
+}
+
+func (t *Tagged) Del(key string) error {
+	return mdel(t.store, []string{key, tagMetaKey(key)})
+}
+
+// Invalidate drops every entry carrying any of tags.
+func (t *Tagged) Invalidate(tags ...string) error {
+	for _, tag := range tags {
+		if _, err := t.bump(tag, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versions reads the current version of each tag. Counters are read with a
+// zero increment, which also works on backends that keep them natively.
+func (t *Tagged) versions(tags []string) ([]int64, error) {
+	versions := make([]int64, len(tags))
+	for i, tag := range tags {
+		v, err := t.bump(tag, 0)
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = v
+	}
+	return versions, nil
+}
+
+// bump adds delta to the version of tag. Seeded counters are never as low
+// as delta, so a result equal to it means the counter was missing and is
+// seeded now. The seed is in microseconds, as Redis scripts return numbers
+// as doubles and lose precision beyond 2^53.
+func (t *Tagged) bump(tag string, delta int64) (int64, error) {
+	v, err := t.store.Incr(tagKey(tag), delta, 0)
+	if err != nil || v != delta {
+		return v, err
+	}
+	return t.store.Incr(tagKey(tag), time.Now().UnixNano()/int64(time.Microsecond), 0)
+}
+
+func tagKey(tag string) string {
+	return "tag:" + tag
+}
+
+func tagMetaKey(key string) string {
+	return key + "#tags"
+}
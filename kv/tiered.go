@@ -0,0 +1,149 @@
+package kv
+
+import (
+	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type tieredOptions struct {
+	l1TTL   time.Duration
+	channel string
+}
+
+type TieredOption func(opts *tieredOptions)
+
+// L1TTL bounds how long an entry lives in the in-process tier, and therefore
+// how stale it can get if an invalidation message is lost. Entries filled
+// from Redis never outlive their remaining TTL there.
+func L1TTL(v time.Duration) TieredOption {
+	return func(opts *tieredOptions) {
+		opts.l1TTL = v
+	}
+}
+
+// InvalidationChannel names the Redis pub/sub channel replicas use to drop
+// their L1 copies. Replicas sharing keys must use the same channel.
+func InvalidationChannel(v string) TieredOption {
+	return func(opts *tieredOptions) {
+		opts.channel = v
+	}
+}
+
+// Tiered consults an in-process Memory before Redis. Writes and deletes are
+// broadcast so other replicas drop their L1 copy of the key. L1 holds the
+// encoded values, so like Redis every Get decodes a fresh copy.
+type Tiered struct {
+	l1     *Memory
+	l2     *Redis
+	opts   tieredOptions
+	id     string
+	pubsub *redis.PubSub
+	closed chan struct{}
+}
+
+func NewTiered(l1 *Memory, l2 *Redis, opts ...TieredOption) (*Tiered, error) {
+	tieredOps := tieredOptions{
+		l1TTL:   time.Minute,
+		channel: "kv:invalidate",
+	}
+	for _, opt := range opts {
+		opt(&tieredOps)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	pubsub, err := l2.subscribe(tieredOps.channel)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tiered{
+		l1:     l1,
+		l2:     l2,
+		opts:   tieredOps,
+		id:     id,
+		pubsub: pubsub,
+		closed: make(chan struct{}),
+	}
+	go t.listen()
+	return t, nil
+}
+
+func (t *Tiered) Set(key string, o interface{}, ttl time.Duration) error {
+	b, err := t.l2.codec.Marshal(o)
+	if err != nil {
+		return err
+	}
+	if err := t.l2.client.Set(key, b, ttl).Err(); err != nil {
+		return err
+	}
+	t.l1.Set(key, b, t.l1Expiration(ttl))
+	return t.invalidate(key)
+}
+
+func (t *Tiered) Get(key string, o interface{}) error {
+	var b []byte
+	err := t.l1.Get(key, &b)
+	if err == ErrKeyMiss {
+		var ttl time.Duration
+		b, ttl, err = t.l2.getBytes(key)
+		if err == nil && ttl >= 0 {
+			t.l1.Set(key, b, t.l1Expiration(ttl))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.l2.codec.Unmarshal(b, o); err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
+	return nil
+}
+
+func (t *Tiered) Del(key string) error {
+	t.l1.Del(key)
+	if err := t.l2.Del(key); err != nil {
+		return err
+	}
+	return t.invalidate(key)
+}
+
+// Close stops listening for invalidations from other replicas.
+func (t *Tiered) Close() error {
+	close(t.closed)
+	return t.pubsub.Close()
+}
+
+func (t *Tiered) l1Expiration(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > t.opts.l1TTL {
+		return t.opts.l1TTL
+	}
+	return ttl
+}
+
+func (t *Tiered) invalidate(key string) error {
+	return t.l2.publish(t.opts.channel, t.id+" "+key)
+}
+
+func (t *Tiered) listen() {
+	for {
+		msg, err := t.pubsub.ReceiveMessage()
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+		parts := strings.SplitN(msg.Payload, " ", 2)
+		if len(parts) != 2 || parts[0] == t.id {
+			continue
+		}
+		t.l1.Del(parts[1])
+	}
+}
@@ -0,0 +1,85 @@
+package kv_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xtimeline/gox/kv"
+)
+
+type tieredValue struct {
+	M map[string]int
+}
+
+func newTiered(t *testing.T, r *kv.Redis) (*kv.Tiered, *kv.Memory) {
+	l1 := kv.NewMemory()
+	tr, err := kv.NewTiered(l1, r)
+	if err != nil {
+		t.Fatalf("NewTiered: %v", err)
+	}
+	return tr, l1
+}
+
+func TestTieredCopies(t *testing.T) {
+	r := newRedis(t)
+	tr, _ := newTiered(t, r)
+	defer tr.Close()
+	key := "tiered:copies:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	in := tieredValue{M: map[string]int{"x": 1}}
+	if err := tr.Set(key, in, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	in.M["x"] = 2
+	var v tieredValue
+	if err := tr.Get(key, &v); err != nil || v.M["x"] != 1 {
+		t.Fatalf("Get after changing the set value: got %v, %v; want 1", v.M, err)
+	}
+	v.M["x"] = 99
+	var w tieredValue
+	if err := tr.Get(key, &w); err != nil || w.M["x"] != 1 {
+		t.Fatalf("Get after changing a read value: got %v, %v; want 1", w.M, err)
+	}
+}
+
+func TestTieredInvalidation(t *testing.T) {
+	r := newRedis(t)
+	a, _ := newTiered(t, r)
+	defer a.Close()
+	b, _ := newTiered(t, r)
+	defer b.Close()
+	key := "tiered:inval:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	a.Set(key, "v1", time.Minute)
+	var s string
+	if err := b.Get(key, &s); err != nil || s != "v1" {
+		t.Fatalf("Get: got %q, %v", s, err)
+	}
+	a.Set(key, "v2", time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	if err := b.Get(key, &s); err != nil || s != "v2" {
+		t.Fatalf("Get after Set on another replica: got %q, %v; want v2", s, err)
+	}
+	a.Del(key)
+	time.Sleep(100 * time.Millisecond)
+	if err := b.Get(key, &s); err != kv.ErrKeyMiss {
+		t.Fatalf("Get after Del on another replica: got %v, want ErrKeyMiss", err)
+	}
+}
+
+func TestTieredL1TTL(t *testing.T) {
+	r := newRedis(t)
+	tr, l1 := newTiered(t, r)
+	defer tr.Close()
+	key := "tiered:ttl:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	r.Set(key, "v", 5*time.Second)
+	var s string
+	if err := tr.Get(key, &s); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ttl, err := l1.TTL(key); err != nil || ttl > 5*time.Second {
+		t.Fatalf("L1 TTL: got %v, %v; want at most 5s", ttl, err)
+	}
+}
@@ -0,0 +1,248 @@
+package kv
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type EventType int
+
+const (
+	// EventSet means the key was written.
+	EventSet EventType = iota
+	// EventDel means the key was deleted, or evicted to free memory.
+	EventDel
+	// EventExpire means the key outlived its ttl.
+	EventExpire
+	// EventOverflow means events were dropped because the receiver fell
+	// too far behind. Its Key is empty.
+	EventOverflow
+)
+
+// watchQueue is how many events a subscription queues for a receiver that
+// is not keeping up before dropping them.
+const watchQueue = 10000
+
+type Event struct {
+	Type EventType
+	Key  string
+}
+
+// Watcher is implemented by stores that report changes to their keys.
+// Expirations are reported when the store notices them, which may be some
+// time after the ttl ran out.
+type Watcher interface {
+	Watch(prefix string) (*Subscription, error)
+}
+
+var (
+	_ Watcher = (*Memory)(nil)
+	_ Watcher = (*Redis)(nil)
+)
+
+// Subscription delivers the events of keys starting with its prefix, in the
+// order the store reported them. Events are queued while the receiver is
+// busy, so a slow receiver never blocks the store. Once the queue is full,
+// further events are dropped and replaced by a single EventOverflow.
+type Subscription struct {
+	prefix string
+	c      chan Event
+	mu     sync.Mutex
+	queue  []Event
+	wake   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	stop   func() error
+}
+
+func newSubscription(prefix string) *Subscription {
+	s := &Subscription{
+		prefix: prefix,
+		c:      make(chan Event),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.pump()
+	return s
+}
+
+// Events returns the channel events are delivered on. It is closed once
+// the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+func (s *Subscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		if s.stop != nil {
+			err = s.stop()
+		}
+	})
+	return err
+}
+
+func (s *Subscription) push(ev Event) {
+	if !strings.HasPrefix(ev.Key, s.prefix) {
+		return
+	}
+	s.mu.Lock()
+	switch {
+	case len(s.queue) < watchQueue:
+		s.queue = append(s.queue, ev)
+	case s.queue[len(s.queue)-1].Type != EventOverflow:
+		s.queue = append(s.queue, Event{Type: EventOverflow})
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) pump() {
+	defer close(s.c)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+		for _, ev := range queue {
+			select {
+			case <-s.done:
+				return
+			case s.c <- ev:
+			}
+		}
+	}
+}
+
+func (m *Memory) Watch(prefix string) (*Subscription, error) {
+	// the subscription must not keep Memory reachable, or its finalizer
+	// would never stop the sweeper
+	mem := m.memory
+	s := newSubscription(prefix)
+	s.stop = func() error {
+		mem.mu.Lock()
+		defer mem.unlock()
+		for i, w := range mem.watches {
+			if w == s {
+				mem.watches = append(mem.watches[:i], mem.watches[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	mem.mu.Lock()
+	defer mem.unlock()
+	mem.watches = append(mem.watches, s)
+	return s, nil
+}
+
+// notify queues an event for the watchers; they receive it when mu is
+// released.
+func (m *memory) notify(typ EventType, key string) {
+	if len(m.watches) > 0 {
+		m.events = append(m.events, Event{Type: typ, Key: key})
+	}
+}
+
+// Watch uses keyspace notifications, which the server must have enabled
+// for generic and string commands, expirations and evictions, for example
+// with "notify-keyspace-events Kg$xe". In cluster mode every master known
+// when Watch is called is subscribed to.
+func (r *Redis) Watch(prefix string) (*Subscription, error) {
+	channel := "__keyspace@" + strconv.Itoa(r.db) + "__:"
+	pubsubs, err := r.psubscribe(channel + escapeGlob(prefix) + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSubscription(prefix)
+	s.stop = func() error {
+		var err error
+		for _, pubsub := range pubsubs {
+			if e := pubsub.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+	for _, pubsub := range pubsubs {
+		go func(pubsub *redis.PubSub) {
+			for {
+				msg, err := pubsub.ReceiveMessage()
+				if err != nil {
+					select {
+					case <-s.done:
+						return
+					case <-time.After(time.Second):
+						continue
+					}
+				}
+				typ, ok := keyspaceEvent(msg.Payload)
+				if ok {
+					s.push(Event{Type: typ, Key: strings.TrimPrefix(msg.Channel, channel)})
+				}
+			}
+		}(pubsub)
+	}
+	return s, nil
+}
+
+// psubscribe subscribes to pattern on every node holding keys.
+func (r *Redis) psubscribe(pattern string) ([]*redis.PubSub, error) {
+	switch c := r.client.(type) {
+	case *redis.Client:
+		pubsub, err := c.PSubscribe(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return []*redis.PubSub{pubsub}, nil
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		var pubsubs []*redis.PubSub
+		err := c.ForEachMaster(func(master *redis.Client) error {
+			pubsub, err := master.PSubscribe(pattern)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			pubsubs = append(pubsubs, pubsub)
+			return nil
+		})
+		if err != nil {
+			for _, pubsub := range pubsubs {
+				pubsub.Close()
+			}
+			return nil, err
+		}
+		return pubsubs, nil
+	}
+	return nil, errUnknownClient
+}
+
+// keyspaceEvent maps the command named by a keyspace notification to an
+// event. Commands that only change the expiration are not reported.
+func keyspaceEvent(cmd string) (EventType, bool) {
+	switch cmd {
+	case "del", "evicted", "rename_from":
+		return EventDel, true
+	case "expired":
+		return EventExpire, true
+	case "expire", "persist":
+		return 0, false
+	}
+	return EventSet, true
+}
@@ -0,0 +1,315 @@
+package kv
+
+import (
+	"sort"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// SortedSet is a collection of distinct values ordered by a score, for
+// leaderboards and priority queues. Members with equal scores are ordered
+// by their encoded form. Score and Rank return ErrKeyMiss for a missing
+// member; ranks start at 0 for the lowest score. Range and RevRange decode
+// members in ascending and descending order into the slice objs points to
+// and return their scores.
+type SortedSet interface {
+	Add(member interface{}, score float64) error
+	Incr(member interface{}, delta float64) (float64, error)
+	Remove(members ...interface{}) (int64, error)
+	Score(member interface{}) (float64, error)
+	Rank(member interface{}) (int64, error)
+	Range(start, stop int64, objs interface{}) ([]float64, error)
+	RevRange(start, stop int64, objs interface{}) ([]float64, error)
+	Len() (int64, error)
+}
+
+type redisSortedSet struct {
+	r   *Redis
+	key string
+}
+
+func (r *Redis) SortedSetAt(key string) SortedSet {
+	return &redisSortedSet{r: r, key: key}
+}
+
+func (z *redisSortedSet) Add(member interface{}, score float64) error {
+	b, err := marshalMember(z.r.codec, member)
+	if err != nil {
+		return err
+	}
+	return wrongType(z.r.client.ZAdd(z.key, redis.Z{Score: score, Member: b}).Err())
+}
+
+func (z *redisSortedSet) Incr(member interface{}, delta float64) (float64, error) {
+	b, err := marshalMember(z.r.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	score, err := z.r.client.ZIncrBy(z.key, delta, string(b)).Result()
+	return score, wrongType(err)
+}
+
+func (z *redisSortedSet) Remove(members ...interface{}) (int64, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args, err := encodeMembers(z.r.codec, members)
+	if err != nil {
+		return 0, err
+	}
+	n, err := z.r.client.ZRem(z.key, args...).Result()
+	return n, wrongType(err)
+}
+
+func (z *redisSortedSet) Score(member interface{}) (float64, error) {
+	b, err := marshalMember(z.r.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	score, err := z.r.client.ZScore(z.key, string(b)).Result()
+	if err == redis.Nil {
+		return 0, ErrKeyMiss
+	}
+	return score, wrongType(err)
+}
+
+func (z *redisSortedSet) Rank(member interface{}) (int64, error) {
+	b, err := marshalMember(z.r.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	rank, err := z.r.client.ZRank(z.key, string(b)).Result()
+	if err == redis.Nil {
+		return 0, ErrKeyMiss
+	}
+	return rank, wrongType(err)
+}
+
+func (z *redisSortedSet) Range(start, stop int64, objs interface{}) ([]float64, error) {
+	zs, err := z.r.client.ZRangeWithScores(z.key, start, stop).Result()
+	return z.decode(zs, err, objs)
+}
+
+func (z *redisSortedSet) RevRange(start, stop int64, objs interface{}) ([]float64, error) {
+	zs, err := z.r.client.ZRevRangeWithScores(z.key, start, stop).Result()
+	return z.decode(zs, err, objs)
+}
+
+func (z *redisSortedSet) Len() (int64, error) {
+	n, err := z.r.client.ZCard(z.key).Result()
+	return n, wrongType(err)
+}
+
+func (z *redisSortedSet) decode(zs []redis.Z, err error, objs interface{}) ([]float64, error) {
+	if err != nil {
+		return nil, wrongType(err)
+	}
+	raws := make([]string, len(zs))
+	scores := make([]float64, len(zs))
+	for i, member := range zs {
+		raws[i], _ = member.Member.(string)
+		scores[i] = member.Score
+	}
+	if err := decodeAll(z.r.codec, z.key, raws, objs); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+type memSortedSet struct {
+	scores map[string]float64
+}
+
+// sorted returns the members ordered by score.
+func (v *memSortedSet) sorted() []string {
+	members := make([]string, 0, len(v.scores))
+	for member := range v.scores {
+		members = append(members, member)
+	}
+	sort.Sort(byScore{members: members, scores: v.scores})
+	return members
+}
+
+type byScore struct {
+	members []string
+	scores  map[string]float64
+}
+
+func (s byScore) Len() int      { return len(s.members) }
+func (s byScore) Swap(i, j int) { s.members[i], s.members[j] = s.members[j], s.members[i] }
+func (s byScore) Less(i, j int) bool {
+	a, b := s.scores[s.members[i]], s.scores[s.members[j]]
+	if a != b {
+		return a < b
+	}
+	return s.members[i] < s.members[j]
+}
+
+type memorySortedSet struct {
+	m   *memory
+	key string
+}
+
+func (m *Memory) SortedSetAt(key string) SortedSet {
+	return &memorySortedSet{m: m.memory, key: key}
+}
+
+func (z *memorySortedSet) Add(member interface{}, score float64) error {
+	b, err := marshalMember(z.m.opts.codec, member)
+	if err != nil {
+		return err
+	}
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	e, v, err := z.load()
+	if err != nil {
+		return err
+	}
+	v.scores[string(b)] = score
+	z.m.save(z.key, e, v, false)
+	return nil
+}
+
+func (z *memorySortedSet) Incr(member interface{}, delta float64) (float64, error) {
+	b, err := marshalMember(z.m.opts.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	e, v, err := z.load()
+	if err != nil {
+		return 0, err
+	}
+	score := v.scores[string(b)] + delta
+	v.scores[string(b)] = score
+	z.m.save(z.key, e, v, false)
+	return score, nil
+}
+
+func (z *memorySortedSet) Remove(members ...interface{}) (int64, error) {
+	raws := make([]string, len(members))
+	for i, member := range members {
+		b, err := marshalMember(z.m.opts.codec, member)
+		if err != nil {
+			return 0, err
+		}
+		raws[i] = string(b)
+	}
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	e, v, err := z.load()
+	if err != nil || e == nil {
+		return 0, err
+	}
+	var n int64
+	for _, raw := range raws {
+		if _, found := v.scores[raw]; found {
+			delete(v.scores, raw)
+			n++
+		}
+	}
+	if n > 0 {
+		z.m.save(z.key, e, v, len(v.scores) == 0)
+	}
+	return n, nil
+}
+
+func (z *memorySortedSet) Score(member interface{}) (float64, error) {
+	b, err := marshalMember(z.m.opts.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	_, v, err := z.load()
+	if err != nil {
+		return 0, err
+	}
+	score, found := v.scores[string(b)]
+	if !found {
+		return 0, ErrKeyMiss
+	}
+	return score, nil
+}
+
+func (z *memorySortedSet) Rank(member interface{}) (int64, error) {
+	b, err := marshalMember(z.m.opts.codec, member)
+	if err != nil {
+		return 0, err
+	}
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	_, v, err := z.load()
+	if err != nil {
+		return 0, err
+	}
+	if _, found := v.scores[string(b)]; !found {
+		return 0, ErrKeyMiss
+	}
+	for i, m := range v.sorted() {
+		if m == string(b) {
+			return int64(i), nil
+		}
+	}
+	return 0, ErrKeyMiss
+}
+
+func (z *memorySortedSet) Range(start, stop int64, objs interface{}) ([]float64, error) {
+	return z.rangeBy(start, stop, objs, false)
+}
+
+func (z *memorySortedSet) RevRange(start, stop int64, objs interface{}) ([]float64, error) {
+	return z.rangeBy(start, stop, objs, true)
+}
+
+func (z *memorySortedSet) Len() (int64, error) {
+	z.m.mu.Lock()
+	defer z.m.unlock()
+	_, v, err := z.load()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(v.scores)), nil
+}
+
+func (z *memorySortedSet) rangeBy(start, stop int64, objs interface{}, rev bool) ([]float64, error) {
+	z.m.mu.Lock()
+	_, v, err := z.load()
+	var members []string
+	var scores []float64
+	if err == nil {
+		members = v.sorted()
+		if rev {
+			for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+				members[i], members[j] = members[j], members[i]
+			}
+		}
+		from, to := span(start, stop, len(members))
+		members = members[from:to]
+		scores = make([]float64, len(members))
+		for i, member := range members {
+			scores[i] = v.scores[member]
+		}
+	}
+	z.m.unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeAll(z.m.opts.codec, z.key, members, objs); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// load returns the sorted set at key, or a new empty one with a nil entry.
+func (z *memorySortedSet) load() (*entry, *memSortedSet, error) {
+	e, err := z.m.structure(z.key, (*memSortedSet)(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		return nil, &memSortedSet{scores: make(map[string]float64)}, nil
+	}
+	return e, e.value.(*memSortedSet), nil
+}